@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm/clause"
+
+	"github.com/lypolix/FaaS-billing/internal/database"
+	"github.com/lypolix/FaaS-billing/internal/models"
+	"github.com/lypolix/FaaS-billing/internal/services/aggregation"
+)
+
+// leaderLock - ключ advisory lock, которым реплики выбирают ведущего
+const leaderLock = "faas-billing/aggregator"
+
+type daemonConfig struct {
+	Enabled     bool
+	Windows     string
+	Lateness    time.Duration
+	Concurrency int
+	Batch       int
+	Poll        time.Duration
+	Backfill    time.Duration
+	MetricsAddr string
+}
+
+var (
+	leaderGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "aggregator_leader",
+		Help: "1 if this replica holds the aggregator advisory lock",
+	})
+	checkpointGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "aggregator_checkpoint_timestamp_seconds",
+		Help: "End of the last contiguous aggregated window",
+	}, []string{"window_size"})
+	lagGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "aggregator_lag_seconds",
+		Help: "Time since the end of the last contiguous aggregated window",
+	}, []string{"window_size"})
+	pendingGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "aggregator_pending_windows",
+		Help: "Windows ready for aggregation but not yet aggregated",
+	}, []string{"window_size"})
+	windowsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aggregator_windows_total",
+		Help: "Aggregated windows by result",
+	}, []string{"window_size", "result"})
+	lateWindowsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aggregator_late_windows_total",
+		Help: "Already aggregated windows re-aggregated because of late events",
+	}, []string{"window_size"})
+	windowDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "aggregator_window_duration_seconds",
+		Help:    "Time to aggregate one window",
+		Buckets: []float64{0.05, 0.1, 0.3, 1, 3, 10, 30},
+	}, []string{"window_size"})
+)
+
+type windowSpec struct {
+	Size     string
+	Duration time.Duration
+}
+
+// errNotLeader - блокировка ведущего потеряна посреди цикла
+var errNotLeader = errors.New("leader lock lost")
+
+// runDaemon агрегирует окна одного размера непрерывно: AggregateWindow
+// удаляет учтённые сырые события, поэтому из сырых данных считается только
+// один размер, более крупные строит cmd/rollup. Хранится checkpoint
+// (aggregation_checkpoints): конец последнего окна, до которого посчитано
+// всё. За цикл окна после checkpoint, закончившиеся не позже чем
+// -lateness назад, считаются параллельно (не больше -concurrency сразу,
+// не больше -batch за цикл), и checkpoint сдвигается до первого окна с
+// ошибкой; уже отмеченные окна (например, ручным запуском)
+// пропускаются. После простоя пропущенные окна догоняются пачками без пауз.
+// Работает только реплика, взявшая advisory lock, остальные ждут;
+// блокировка проверяется перед каждым окном.
+func runDaemon(cfg daemonConfig, engine *aggregation.Engine) error {
+	if cfg.Concurrency < 1 || cfg.Batch < 1 {
+		return errors.New("-concurrency and -batch must be positive")
+	}
+	size := strings.TrimSpace(cfg.Windows)
+	if strings.Contains(size, ",") {
+		return fmt.Errorf("-windows accepts one raw window size, got %q: coarser sizes are built by cmd/rollup", cfg.Windows)
+	}
+	d, err := aggregation.ParseWindowSize(size)
+	if err != nil {
+		return err
+	}
+	w := windowSpec{Size: size, Duration: d}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	prometheus.MustRegister(leaderGauge, checkpointGauge, lagGauge, pendingGauge,
+		windowsTotal, lateWindowsTotal, windowDuration)
+	if cfg.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+		srv := &http.Server{Addr: cfg.MetricsAddr, Handler: mux}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("metrics server: %v", err)
+			}
+		}()
+		defer srv.Close()
+	}
+
+	sqlDB, err := database.DB.DB()
+	if err != nil {
+		return err
+	}
+	lock := &advisoryLock{db: sqlDB, name: leaderLock}
+	defer lock.release()
+
+	log.Printf("aggregator daemon: windows=%s concurrency=%d lateness=%s", cfg.Windows, cfg.Concurrency, cfg.Lateness)
+	for ctx.Err() == nil {
+		if !lock.held(ctx) {
+			leaderGauge.Set(0)
+			ok, err := lock.acquire(ctx)
+			if err != nil {
+				log.Printf("leader lock: %v", err)
+			}
+			if !ok {
+				sleep(ctx, cfg.Poll)
+				continue
+			}
+			log.Printf("aggregator daemon: became leader")
+		}
+		leaderGauge.Set(1)
+
+		more, err := runCycle(ctx, cfg, engine, w, lock)
+		if err != nil {
+			log.Printf("aggregate %s: %v", w.Size, err)
+		}
+		if !more || err != nil {
+			sleep(ctx, cfg.Poll)
+		}
+	}
+	log.Printf("aggregator daemon: stopped")
+	return nil
+}
+
+// runCycle обрабатывает одну пачку окон размера; true - остались окна.
+// Блокировка держится на своём соединении, а окна считаются на соединениях
+// пула, поэтому перед каждым окном она проверяется: потерявшая её реплика
+// не начинает новых окон (двойной учёт окна исключает блокировка окна в
+// AggregateWindow).
+func runCycle(ctx context.Context, cfg daemonConfig, engine *aggregation.Engine, w windowSpec, lock *advisoryLock) (bool, error) {
+	now := time.Now().UTC()
+	from, err := loadCheckpoint(w, now.Add(-cfg.Backfill))
+	if err != nil {
+		return false, err
+	}
+	target := now.Add(-cfg.Lateness).Truncate(w.Duration)
+	pending := int(target.Sub(from) / w.Duration)
+	reportProgress(w.Size, from, pending, now)
+	if pending <= 0 {
+		return false, nil
+	}
+	n := pending
+	if n > cfg.Batch {
+		n = cfg.Batch
+	}
+
+	var marked []time.Time
+	if err := database.DB.Model(&models.AggregationWindow{}).
+		Where("window_size = ? AND source = '' AND window_start >= ? AND window_start < ?",
+			w.Size, from, from.Add(time.Duration(n)*w.Duration)).
+		Pluck("window_start", &marked).Error; err != nil {
+		return false, err
+	}
+	done := make(map[int64]bool, len(marked))
+	for _, t := range marked {
+		done[t.Unix()] = true
+	}
+
+	errs := make([]error, n)
+	sem := make(chan struct{}, cfg.Concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		start := from.Add(time.Duration(i) * w.Duration)
+		if done[start.Unix()] {
+			continue
+		}
+		if ctx.Err() == nil {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+			}
+		}
+		stopErr := ctx.Err()
+		if stopErr == nil && !lock.held(ctx) {
+			stopErr = errNotLeader
+		}
+		if stopErr != nil {
+			// не начатые окна не сдвигают checkpoint
+			for j := i; j < n; j++ {
+				errs[j] = stopErr
+			}
+			break
+		}
+		wg.Add(1)
+		go func(i int, start time.Time) {
+			defer func() { <-sem; wg.Done() }()
+			t0 := time.Now()
+			_, errs[i] = engine.AggregateWindow(start, w.Size, nil)
+			windowDuration.WithLabelValues(w.Size).Observe(time.Since(t0).Seconds())
+			if errs[i] != nil {
+				windowsTotal.WithLabelValues(w.Size, "error").Inc()
+				return
+			}
+			windowsTotal.WithLabelValues(w.Size, "ok").Inc()
+		}(i, start)
+	}
+	wg.Wait()
+
+	// checkpoint - до первого окна с ошибкой: оно повторится в следующем цикле
+	advanced := 0
+	var firstErr error
+	for _, err := range errs {
+		if err != nil {
+			firstErr = err
+			break
+		}
+		advanced++
+	}
+	cp := from.Add(time.Duration(advanced) * w.Duration)
+	if advanced > 0 {
+		if err := database.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&models.AggregationCheckpoint{
+			WindowSize: w.Size,
+			WindowEnd:  cp,
+			UpdatedAt:  time.Now(),
+		}).Error; err != nil {
+			return false, err
+		}
+	}
+	reportProgress(w.Size, cp, pending-advanced, time.Now().UTC())
+	if advanced > 0 {
+		log.Printf("aggregate %s: windows=%d checkpoint=%s pending=%d", w.Size, advanced, cp.Format(time.RFC3339), pending-advanced)
+	}
+	if firstErr != nil {
+		return false, fmt.Errorf("window %s: %w", cp.Format(time.RFC3339), firstErr)
+	}
+
+	if !lock.held(ctx) {
+		return false, errNotLeader
+	}
+	// опоздавшие события ищутся только в пределах lateness до checkpoint:
+	// полный просмотр usage_raws - разовый запуск с -end
+	late, missed, err := engine.ReaggregateLate(cp.Add(-cfg.Lateness), cp, w.Size)
+	lateWindowsTotal.WithLabelValues(w.Size).Add(float64(late))
+	logLate(w.Size, late, missed)
+	return pending > advanced, err
+}
+
+// loadCheckpoint - начало первого не посчитанного окна. Без checkpoint
+// продолжаем с последнего отмеченного окна, а без отметок - с первого
+// сырого события, но не раньше floor
+func loadCheckpoint(w windowSpec, floor time.Time) (time.Time, error) {
+	var cp models.AggregationCheckpoint
+	err := database.DB.Where("window_size = ?", w.Size).Limit(1).Find(&cp).Error
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !cp.WindowEnd.IsZero() {
+		return cp.WindowEnd.UTC(), nil
+	}
+
+	var last sql.NullTime
+	if err := database.DB.Model(&models.AggregationWindow{}).
+		Where("window_size = ? AND source = ''", w.Size).
+		Select("MAX(window_end)").Scan(&last).Error; err != nil {
+		return time.Time{}, err
+	}
+	if last.Valid {
+		return last.Time.UTC(), nil
+	}
+
+	from := floor.UTC().Truncate(w.Duration)
+	var first sql.NullTime
+	if err := database.DB.Model(&models.UsageRaw{}).
+		Select("MIN(timestamp)").Scan(&first).Error; err != nil {
+		return time.Time{}, err
+	}
+	if first.Valid && first.Time.After(from) {
+		from = first.Time.UTC().Truncate(w.Duration)
+	}
+	return from, nil
+}
+
+func reportProgress(size string, checkpoint time.Time, pending int, now time.Time) {
+	if pending < 0 {
+		pending = 0
+	}
+	checkpointGauge.WithLabelValues(size).Set(float64(checkpoint.Unix()))
+	lagGauge.WithLabelValues(size).Set(now.Sub(checkpoint).Seconds())
+	pendingGauge.WithLabelValues(size).Set(float64(pending))
+}
+
+// advisoryLock - сессионный advisory lock на выделенном соединении:
+// блокировка держится, пока соединение живо, и снимается сервером при
+// его обрыве, после чего ведущим становится другая реплика
+type advisoryLock struct {
+	db   *sql.DB
+	name string
+	conn *sql.Conn
+}
+
+func (l *advisoryLock) acquire(ctx context.Context) (bool, error) {
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	var ok bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", l.name).Scan(&ok); err != nil || !ok {
+		conn.Close()
+		return false, err
+	}
+	l.conn = conn
+	return true, nil
+}
+
+// held проверяет, что соединение с блокировкой живо
+func (l *advisoryLock) held(ctx context.Context) bool {
+	if l.conn == nil {
+		return false
+	}
+	if err := l.conn.PingContext(ctx); err != nil {
+		log.Printf("leader lock connection lost: %v", err)
+		l.conn.Close()
+		l.conn = nil
+		return false
+	}
+	return true
+}
+
+func (l *advisoryLock) release() {
+	if l.conn == nil {
+		return
+	}
+	l.conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", l.name)
+	l.conn.Close()
+	l.conn = nil
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
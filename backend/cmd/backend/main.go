@@ -32,6 +32,15 @@ func main() {
 
 	h := handlers.NewHandler()
 
+	// курсы валют из файла (JSON или CSV base,quote,rate,date)
+	if path := os.Getenv("EXCHANGE_RATES_FILE"); path != "" {
+		if n, err := h.CurrencyService.LoadRatesFile(path); err != nil {
+			log.Printf("failed to load exchange rates from %s: %v", path, err)
+		} else {
+			log.Printf("loaded %d exchange rates from %s", n, path)
+		}
+	}
+
 	api := r.Group("/api/v1")
 	{
 		api.GET("/health", func(c *gin.Context) { c.JSON(200, gin.H{"status": "ok"}) })
@@ -42,8 +51,51 @@ func main() {
 		api.GET("/tenants/:id", h.GetTenant)
 
 		api.GET("/pricing-plans", h.GetPricingPlans)
+		api.POST("/pricing-plans", h.CreatePricingPlan)
+		api.PUT("/pricing-plans/:id", h.UpdatePricingPlan)
+		api.POST("/pricing-plans/:id/archive", h.ArchivePricingPlan)
+		api.GET("/pricing-plans/:id/versions", h.GetPricingPlanVersions)
         api.PUT("/tenants/:id/pricing-plan", h.SetTenantPricingPlan)
         api.GET("/tenants/:id/pricing-plan", h.GetTenantPricingPlan)
+		api.GET("/tenants/:id/pricing-plan/history", h.GetTenantPlanHistory)
+		api.GET("/tenants/:id/available-plans", h.GetTenantAvailablePlans)
+		api.GET("/tenants/:id/costs", h.GetTenantCosts)
+
+		// currencies
+		api.GET("/exchange-rates", h.GetExchangeRates)
+		api.POST("/exchange-rates", h.UpsertExchangeRates)
+
+		// taxes
+		api.GET("/tax-profiles", h.GetTaxProfiles)
+		api.POST("/tax-profiles", h.CreateTaxProfile)
+		api.PUT("/tenants/:id/tax-profile", h.SetTenantTaxProfile)
+
+		// contracts
+		api.GET("/tenants/:id/contracts", h.GetTenantContracts)
+		api.POST("/tenants/:id/contracts", h.CreateContract)
+		api.PUT("/contracts/:id", h.UpdateContract)
+
+		// credits & balance
+		api.POST("/tenants/:id/credits", h.GrantCredits)
+		api.POST("/tenants/:id/promo-codes/redeem", h.RedeemPromoCode)
+		api.GET("/tenants/:id/ledger", h.GetTenantLedger)
+		api.GET("/promo-codes", h.GetPromoCodes)
+		api.POST("/promo-codes", h.CreatePromoCode)
+
+		// budgets & alerts
+		api.GET("/tenants/:id/budgets", h.GetTenantBudgets)
+		api.POST("/tenants/:id/budgets", h.CreateBudget)
+		api.PUT("/budgets/:id", h.UpdateBudget)
+		api.GET("/budgets/:id/status", h.GetBudgetStatus)
+		api.GET("/budgets/:id/alerts", h.GetBudgetAlerts)
+		api.POST("/budgets/evaluate", h.EvaluateBudgets)
+
+		// spending caps: приостановка при превышении лимита
+		api.GET("/tenants/:id/spending-caps", h.GetTenantSpendingCaps)
+		api.POST("/tenants/:id/spending-caps", h.CreateSpendingCap)
+		api.PUT("/spending-caps/:id", h.UpdateSpendingCap)
+		api.POST("/spending-caps/evaluate", h.EvaluateSpendingCaps)
+		api.GET("/suspensions", h.GetSuspensions)
 
 		// services
 		api.POST("/services", h.CreateService)
@@ -54,6 +106,10 @@ func main() {
 
 		// usage aggregates
 		api.GET("/usage-aggregates", h.GetUsageAggregates)
+		api.GET("/usage-aggregates/export", h.ExportUsageAggregates)
+		api.GET("/usage-aggregates/query", h.QueryUsage)
+		api.GET("/tenants/:id/late-windows", h.GetTenantLateWindows)
+		api.GET("/tenants/:id/adjustments", h.GetTenantAdjustments)
 
 		// metrics ingest/aggregate
 		api.POST("/metrics/ingest", h.IngestMetrics)
@@ -63,6 +119,17 @@ func main() {
 		api.POST("/billing/calculate", h.CalculateCost)
 		api.POST("/billing/generate", h.GenerateBill)
 
+		// bills
+		api.GET("/bills", h.GetBills)
+		api.GET("/bills/line-items/export", h.ExportBillLineItems)
+		api.GET("/bills/:id", h.GetBill)
+		api.POST("/bills/:id/finalize", h.FinalizeBill)
+		api.POST("/bills/:id/void", h.VoidBill)
+		api.POST("/bills/:id/pay", h.PayBill)
+		api.GET("/bills/:id/invoice.html", h.GetBillInvoiceHTML)
+		api.GET("/bills/:id/invoice.pdf", h.GetBillInvoicePDF)
+		api.GET("/billing-runs", h.GetBillingRuns)
+
 		// ml (прокси)
 		api.POST("/forecast/cost", h.ProxyForecast)
 	}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/lypolix/FaaS-billing/internal/database"
+	"github.com/lypolix/FaaS-billing/internal/services"
+)
+
+func main() {
+	var (
+		month     string
+		from      string
+		tenantStr string
+		window    string
+	)
+	flag.StringVar(&month, "month", "", "Month to close, YYYY-MM. Empty = previous month in each tenant's timezone")
+	flag.StringVar(&from, "from", "", "Backfill start month, YYYY-MM. Runs every month from -from to -month (or the previous month in each tenant's timezone)")
+	flag.StringVar(&tenantStr, "tenant", "", "Optional tenant UUID to bill only one tenant")
+	flag.StringVar(&window, "window", getEnv("AGG_WINDOW", "1m"), "Aggregation window used to catch up raw usage: 1m,5m,1h,1d")
+	flag.Parse()
+
+	var tenantID *uuid.UUID
+	if tenantStr != "" {
+		id, err := uuid.Parse(tenantStr)
+		if err != nil {
+			log.Fatalf("invalid -tenant: %v", err)
+		}
+		tenantID = &id
+	}
+
+	months := []string{month}
+	if from != "" {
+		to := month
+		if to == "" {
+			// до предыдущего месяца по UTC, а последний месяц каждого
+			// арендатора - в его таймзоне (пустой Month): у арендаторов
+			// восточнее UTC он может быть на месяц позже, уже закрытые
+			// месяцы повторный проход пропускает
+			now := time.Now().UTC()
+			to = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0).Format("2006-01")
+		}
+		var err error
+		months, err = services.MonthsBetween(from, to)
+		if err != nil {
+			log.Fatalf("invalid backfill range: %v", err)
+		}
+		if month == "" {
+			months = append(months, "")
+		}
+	}
+
+	database.Connect()
+	svc := services.NewBillingRunService(database.DB)
+
+	failed := false
+	for _, m := range months {
+		run, err := svc.Run(services.BillingRunOptions{
+			Month:      m,
+			TenantID:   tenantID,
+			WindowSize: window,
+		})
+		if err != nil {
+			log.Fatalf("billing run: %v", err)
+		}
+		for _, it := range run.Items {
+			log.Printf("billing-run: tenant=%s month=%s outcome=%s %s", it.TenantID, it.Month, it.Outcome, it.Message)
+		}
+		log.Printf("billing-run %s: status=%s finalized=%d skipped=%d failed=%d",
+			run.ID, run.Status, run.Succeeded, run.Skipped, run.Failed)
+		if run.Failed > 0 {
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func getEnv(k, d string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return d
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/lypolix/FaaS-billing/internal/database"
+	"github.com/lypolix/FaaS-billing/internal/services"
+)
+
+func main() {
+	var (
+		tenantStr string
+		interval  time.Duration
+	)
+	flag.StringVar(&tenantStr, "tenant", "", "Optional tenant UUID to check only its budgets")
+	flag.DurationVar(&interval, "interval", 0, "Repeat every interval (e.g. 15m). 0 = run once")
+	flag.Parse()
+
+	var tenantID *uuid.UUID
+	if tenantStr != "" {
+		id, err := uuid.Parse(tenantStr)
+		if err != nil {
+			log.Fatalf("invalid -tenant: %v", err)
+		}
+		tenantID = &id
+	}
+
+	database.Connect()
+	svc := services.NewBudgetService(database.DB, services.NotifiersFromEnv()...)
+
+	for {
+		statuses, err := svc.Evaluate(time.Now(), tenantID)
+		if err != nil {
+			log.Fatalf("budget alerts: %v", err)
+		}
+		fired := 0
+		for _, st := range statuses {
+			fired += len(st.Fired)
+		}
+		log.Printf("budget-alerts: checked=%d fired=%d", len(statuses), fired)
+
+		if interval <= 0 {
+			return
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/lypolix/FaaS-billing/internal/database"
+	"github.com/lypolix/FaaS-billing/internal/services"
+)
+
+func main() {
+	var (
+		grace        time.Duration
+		retentionStr string
+		force        bool
+		interval     time.Duration
+	)
+	flag.DurationVar(&grace, "grace", 10*time.Minute, "Roll up a window only this long after it ends")
+	flag.StringVar(&retentionStr, "retention", "1m=7d,1h=90d", "Retention per window size (size=duration, 0 = forever); sizes not listed are kept forever")
+	flag.BoolVar(&force, "force", false, "Roll up windows even if not all source windows are marked complete")
+	flag.DurationVar(&interval, "interval", 0, "Repeat every interval (e.g. 15m). 0 = run once")
+	flag.Parse()
+
+	retention, err := services.ParseRetention(retentionStr)
+	if err != nil {
+		log.Fatalf("invalid -retention: %v", err)
+	}
+
+	database.Connect()
+	svc := services.NewRollupService(database.DB)
+
+	for {
+		now := time.Now()
+		// уровни по порядку: дневная свёртка видит только что свёрнутые часы
+		for _, level := range services.DefaultRollups {
+			n, err := svc.Rollup(level, services.RollupOptions{Now: now, Grace: grace, Force: force})
+			if err != nil {
+				log.Fatalf("rollup %s: %v", level.Size, err)
+			}
+			log.Printf("rollup: %s from %s windows=%d", level.Size, level.Source, n)
+		}
+
+		deleted, err := svc.ApplyRetention(now, retention, services.DefaultRollups)
+		if err != nil {
+			log.Fatalf("retention: %v", err)
+		}
+		log.Printf("retention: deleted=%d", deleted)
+
+		if interval <= 0 {
+			return
+		}
+		time.Sleep(interval)
+	}
+}
@@ -13,12 +13,23 @@ func main() {
 	database.Connect()
 	database.Migrate()
 
+	vat := models.TaxProfile{
+		ID:     uuid.New(),
+		Code:   "RU_VAT_20",
+		Name:   "НДС 20%",
+		Mode:   models.TaxModeStandard,
+		Rate:   0.20,
+		Active: true,
+	}
+	_ = database.DB.Create(&vat).Error
+
 	tenant := models.Tenant{
 		ID:           uuid.New(),
 		Name:         "Demo Tenant",
 		BillingEmail: "billing@example.com",
 		Currency:     "RUB",
 		Timezone:     "Europe/Moscow",
+		TaxProfileID: &vat.ID,
 	}
 	_ = database.DB.Create(&tenant).Error
 
@@ -45,6 +56,8 @@ func main() {
 		FreeTierInvocations:       1_000_000,
 		FreeTierGBHours:           10.0,
 		FreeTierEgressGB:          100.0,
+		PricesIncludeTax:          true, // цены Yandex Cloud указаны с НДС
+		EffectiveFrom:             time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC),
 		Active:                    true,
 		CreatedAt:                 time.Now(),
 	}
@@ -52,6 +65,12 @@ func main() {
 
 	tenant.PricingPlanID = &plan.ID
 	_ = database.DB.Save(&tenant).Error
+	_ = database.DB.Create(&models.TenantPlanAssignment{
+		ID:            uuid.New(),
+		TenantID:      tenant.ID,
+		PlanGroupID:   plan.GroupID,
+		EffectiveFrom: plan.EffectiveFrom,
+	}).Error
 
 	log.Println("Seed completed")
 }
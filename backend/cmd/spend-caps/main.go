@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/lypolix/FaaS-billing/internal/database"
+	"github.com/lypolix/FaaS-billing/internal/services"
+)
+
+func main() {
+	var (
+		tenantStr string
+		interval  time.Duration
+	)
+	flag.StringVar(&tenantStr, "tenant", "", "Optional tenant UUID to check only its caps")
+	flag.DurationVar(&interval, "interval", 0, "Repeat every interval (e.g. 5m). 0 = run once")
+	flag.Parse()
+
+	var tenantID *uuid.UUID
+	if tenantStr != "" {
+		id, err := uuid.Parse(tenantStr)
+		if err != nil {
+			log.Fatalf("invalid -tenant: %v", err)
+		}
+		tenantID = &id
+	}
+
+	database.Connect()
+	svc := services.NewCapService(database.DB)
+
+	for {
+		statuses, err := svc.Evaluate(time.Now(), tenantID)
+		if err != nil {
+			log.Fatalf("spend caps: %v", err)
+		}
+		suspended := 0
+		for _, st := range statuses {
+			if st.Suspended {
+				suspended++
+			}
+		}
+		log.Printf("spend-caps: checked=%d suspended=%d", len(statuses), suspended)
+
+		if interval <= 0 {
+			return
+		}
+		time.Sleep(interval)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
+	"github.com/google/uuid"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -40,12 +42,251 @@ func Migrate() {
 		&models.Service{},
 		&models.Revision{},
 		&models.PricingPlan{},
+		&models.TenantPlanAssignment{},
+		&models.TaxProfile{},
+		&models.ExchangeRate{},
+		&models.Contract{},
 		&models.UsageRaw{},
 		&models.UsageAggregate{},
+		&models.AggregationWindow{},
+		&models.AggregationCheckpoint{},
+		&models.LateWindow{},
+		&models.UsageAdjustment{},
 		&models.Bill{},
+		&models.InvoiceSequence{},
+		&models.BillingRun{},
+		&models.BillingRunItem{},
+		&models.BalanceEntry{},
+		&models.PromoCode{},
+		&models.Budget{},
+		&models.BudgetAlert{},
+		&models.SpendingCap{},
+		&models.Suspension{},
 	); err != nil {
 		log.Fatal("Failed to migrate: ", err)
 	}
+	if err := migrateConstraints(); err != nil {
+		log.Fatal("Failed to migrate constraints: ", err)
+	}
+	if err := migratePlanVersions(); err != nil {
+		log.Fatal("Failed to migrate plan versions: ", err)
+	}
+	if err := migrateAggregationWindows(); err != nil {
+		log.Fatal("Failed to migrate aggregation windows: ", err)
+	}
+}
+
+// Окна, посчитанные до появления aggregation_windows, считаются
+// завершёнными; окна без данных между ними свёртка не увидит (rollup -force)
+func migrateAggregationWindows() error {
+	var n int64
+	if err := DB.Model(&models.AggregationWindow{}).Count(&n).Error; err != nil || n > 0 {
+		return err
+	}
+	return DB.Exec(`
+		INSERT INTO aggregation_windows (window_size, window_start, window_end, source, rows, completed_at)
+		SELECT window_size, window_start, MAX(window_end), '', COUNT(*), now()
+		FROM usage_aggregates
+		GROUP BY window_size, window_start
+		ON CONFLICT DO NOTHING
+	`).Error
+}
+
+// Планы и назначения, созданные до версионирования: каждый план становится
+// первой версией своего семейства, действующей с начала времён, а текущий
+// план арендатора - его первым назначением
+func migratePlanVersions() error {
+	stmts := []string{
+		`UPDATE pricing_plans SET group_id = id WHERE group_id IS NULL`,
+		`UPDATE pricing_plans SET version = 1 WHERE version IS NULL OR version = 0`,
+		`UPDATE pricing_plans SET effective_from = '1970-01-01' WHERE effective_from IS NULL`,
+		`INSERT INTO tenant_plan_assignments (id, tenant_id, plan_group_id, effective_from, created_at)
+		 SELECT gen_random_uuid(), t.id, p.group_id, '1970-01-01', now()
+		 FROM tenants t JOIN pricing_plans p ON p.id = t.pricing_plan_id
+		 WHERE NOT EXISTS (SELECT 1 FROM tenant_plan_assignments a WHERE a.tenant_id = t.id)`,
+	}
+	for _, q := range stmts {
+		if err := DB.Exec(q).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ограничения, которые AutoMigrate создать не умеет
+func migrateConstraints() error {
+	// Не более одного не аннулированного счёта на любой момент времени
+	if err := DB.Exec(`CREATE EXTENSION IF NOT EXISTS btree_gist`).Error; err != nil {
+		return err
+	}
+	if err := resolveBillOverlaps(); err != nil {
+		return err
+	}
+	if err := DB.Exec(`
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'bills_no_overlap' AND conrelid = 'bills'::regclass) THEN
+				ALTER TABLE bills ADD CONSTRAINT bills_no_overlap
+				EXCLUDE USING gist (
+					tenant_id WITH =,
+					tstzrange(period_start, period_end) WITH &&
+				) WHERE (status <> 'void');
+			END IF;
+		END
+		$$;
+	`).Error; err != nil {
+		return err
+	}
+
+	// Один агрегат на окно и ключ: повторная агрегация окна дополняет его.
+	// revision_id может быть NULL, поэтому индекс по выражению
+	if err := mergeDuplicateAggregates(); err != nil {
+		return err
+	}
+
+	// Журнал баланса только дополняется: исправления вносятся новыми записями
+	return DB.Exec(`
+		CREATE OR REPLACE FUNCTION balance_entries_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'balance_entries is append-only';
+		END
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS balance_entries_append_only ON balance_entries;
+		CREATE TRIGGER balance_entries_append_only
+			BEFORE UPDATE OR DELETE ON balance_entries
+			FOR EACH ROW EXECUTE FUNCTION balance_entries_append_only();
+	`).Error
+}
+
+// Счета, пересекавшиеся до появления bills_no_overlap, разводятся по правилу
+// SaveBill с OverlapSupersede: черновик аннулируется, если его период
+// пересекает выставленный счёт или более новый счёт. Пересечения выставленных
+// (final, paid) счетов автоматически не разрешить - миграция останавливается
+// со списком счетов, один из каждой пары аннулируют вручную в БД.
+func resolveBillOverlaps() error {
+	var exists bool
+	if err := DB.Raw(`SELECT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'bills_no_overlap' AND conrelid = 'bills'::regclass)`).Scan(&exists).Error; err != nil || exists {
+		return err
+	}
+
+	if err := DB.Exec(`
+		UPDATE bills a SET status = ?, voided_at = now(),
+			void_reason = 'superseded by an overlapping bill during migration'
+		WHERE a.status = ? AND EXISTS (
+			SELECT 1 FROM bills b
+			WHERE b.tenant_id = a.tenant_id AND b.id <> a.id AND b.status <> ?
+				AND tstzrange(b.period_start, b.period_end) && tstzrange(a.period_start, a.period_end)
+				AND (b.status <> ? OR (b.created_at, b.id) > (a.created_at, a.id))
+		)
+	`, models.BillStatusVoid, models.BillStatusDraft, models.BillStatusVoid, models.BillStatusDraft).Error; err != nil {
+		return err
+	}
+
+	var conflicts []struct {
+		A uuid.UUID
+		B uuid.UUID
+	}
+	if err := DB.Raw(`
+		SELECT a.id AS a, b.id AS b FROM bills a JOIN bills b
+			ON b.tenant_id = a.tenant_id AND a.id < b.id
+			AND tstzrange(b.period_start, b.period_end) && tstzrange(a.period_start, a.period_end)
+		WHERE a.status <> ? AND b.status <> ?
+		ORDER BY a.id, b.id
+	`, models.BillStatusVoid, models.BillStatusVoid).Scan(&conflicts).Error; err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		pairs := make([]string, len(conflicts))
+		for i, c := range conflicts {
+			pairs[i] = c.A.String() + " & " + c.B.String()
+		}
+		return fmt.Errorf("bills_no_overlap: overlapping issued bills, set status = 'void' on one of each pair: %s",
+			strings.Join(pairs, ", "))
+	}
+	return nil
+}
+
+// Агрегаты одного ключа, накопленные до idx_usage_aggregates_key, - части
+// событий одного окна. Они объединяются по правилам aggregation.MergeAggregate
+// в строку с наибольшим id, остальные удаляются, затем создаётся индекс.
+// Память, учтённая по выделенному объёму (total_memory_mb_hours не равно
+// avg_memory_mb × длина окна), складывается, по замерам - пересчитывается
+// от объединённой средней.
+func mergeDuplicateAggregates() error {
+	var exists bool
+	if err := DB.Raw(`SELECT to_regclass(quote_ident(current_schema()) || '.idx_usage_aggregates_key') IS NOT NULL`).Scan(&exists).Error; err != nil || exists {
+		return err
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`
+			WITH d AS (
+				SELECT *,
+					EXTRACT(EPOCH FROM window_end - window_start) / 3600 AS hours,
+					-- у агрегатов до появления memory_samples вес - число вызовов
+					CASE WHEN memory_samples = 0 AND avg_memory_mb > 0
+						THEN GREATEST(invocations, 1) ELSE memory_samples END AS memory_weight
+				FROM usage_aggregates
+			),
+			g AS (
+				SELECT MAX(id) AS keep_id,
+					SUM(invocations) AS invocations,
+					SUM(total_duration_ms) AS total_duration_ms,
+					SUM(billable_duration_ms) AS billable_duration_ms,
+					COALESCE(SUM(avg_duration_ms * invocations) / NULLIF(SUM(invocations), 0), MAX(avg_duration_ms)) AS avg_duration_ms,
+					COALESCE(SUM(p50_duration_ms * invocations) / NULLIF(SUM(invocations), 0), MAX(p50_duration_ms)) AS p50_duration_ms,
+					MAX(p95_duration_ms) AS p95_duration_ms,
+					MAX(max_memory_mb) AS max_memory_mb,
+					COALESCE(SUM(avg_memory_mb * memory_weight) / NULLIF(SUM(memory_weight), 0), MAX(avg_memory_mb)) AS avg_memory_mb,
+					SUM(memory_samples) AS memory_samples,
+					SUM(total_memory_mb_hours) AS allocated_mb_hours,
+					bool_and(abs(total_memory_mb_hours - avg_memory_mb * hours) < 1e-6) AS measured,
+					MAX(hours) AS hours,
+					SUM(cold_starts) AS cold_starts,
+					SUM(cold_start_init_ms) AS cold_start_init_ms,
+					SUM(cold_start_gb_seconds) AS cold_start_gb_seconds,
+					SUM(errors) AS errors,
+					SUM(egress_bytes) AS egress_bytes,
+					SUM(total_cpu_ms) AS total_cpu_ms
+				FROM d
+				GROUP BY window_size, window_start, tenant_id, service_id, revision_id
+				HAVING COUNT(*) > 1
+			)
+			UPDATE usage_aggregates a SET
+				invocations = g.invocations,
+				total_duration_ms = g.total_duration_ms,
+				billable_duration_ms = g.billable_duration_ms,
+				avg_duration_ms = g.avg_duration_ms,
+				p50_duration_ms = g.p50_duration_ms,
+				p95_duration_ms = g.p95_duration_ms,
+				max_memory_mb = g.max_memory_mb,
+				avg_memory_mb = g.avg_memory_mb,
+				memory_samples = g.memory_samples,
+				total_memory_mb_hours = CASE WHEN g.measured
+					THEN g.avg_memory_mb * g.hours ELSE g.allocated_mb_hours END,
+				cold_starts = g.cold_starts,
+				cold_start_init_ms = g.cold_start_init_ms,
+				cold_start_gb_seconds = g.cold_start_gb_seconds,
+				errors = g.errors,
+				egress_bytes = g.egress_bytes,
+				total_cpu_ms = g.total_cpu_ms
+			FROM g
+			WHERE a.id = g.keep_id
+		`).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`
+			DELETE FROM usage_aggregates a USING usage_aggregates b
+			WHERE a.window_size = b.window_size AND a.window_start = b.window_start
+				AND a.tenant_id = b.tenant_id AND a.service_id = b.service_id
+				AND a.revision_id IS NOT DISTINCT FROM b.revision_id
+				AND a.id < b.id;
+			CREATE UNIQUE INDEX idx_usage_aggregates_key ON usage_aggregates (
+				window_size, window_start, tenant_id, service_id,
+				COALESCE(revision_id, '00000000-0000-0000-0000-000000000000'::uuid)
+			);
+		`).Error
+	})
 }
 
 func getEnv(k, d string) string {
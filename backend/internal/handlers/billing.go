@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/lypolix/FaaS-billing/internal/services"
 )
 
 func (h Handler) CalculateCost(c *gin.Context) {
@@ -23,15 +26,17 @@ func (h Handler) CalculateCost(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	services.LocalizeLineItems(out.LineItems, requestLocale(c, tenantLocale(req.TenantID)))
 
 	c.JSON(http.StatusOK, out)
 }
 
 func (h Handler) GenerateBill(c *gin.Context) {
 	var req struct {
-		TenantID  string    `json:"tenant_id" binding:"required"`
-		StartTime time.Time `json:"start_time" binding:"required"`
-		EndTime   time.Time `json:"end_time" binding:"required"`
+		TenantID      string    `json:"tenant_id" binding:"required"`
+		StartTime     time.Time `json:"start_time" binding:"required"`
+		EndTime       time.Time `json:"end_time" binding:"required"`
+		OverlapPolicy string    `json:"overlap_policy"` // "reject" (по умолчанию) или "supersede"
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -44,10 +49,20 @@ func (h Handler) GenerateBill(c *gin.Context) {
 		return
 	}
 
-	if _, err := h.BillingService.SaveBill(result); err != nil {
+	bill, err := h.BillingService.SaveBill(result, req.OverlapPolicy)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidOverlapPolicy) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrBillOverlap) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save bill: " + err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"message": "bill generated", "bill": result})
+	services.LocalizeLineItems(result.LineItems, requestLocale(c, tenantLocale(req.TenantID)))
+	c.JSON(http.StatusCreated, gin.H{"message": "bill generated", "bill_id": bill.ID, "bill": result})
 }
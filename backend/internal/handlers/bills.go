@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/lypolix/FaaS-billing/internal/database"
+	"github.com/lypolix/FaaS-billing/internal/models"
+	"github.com/lypolix/FaaS-billing/internal/services"
+)
+
+func (h Handler) GetBills(c *gin.Context) {
+	req, ok := pageRequest(c)
+	if !ok {
+		return
+	}
+	page, err := h.BillingService.ListBills(services.BillFilter{
+		TenantID: c.Query("tenant_id"),
+		Status:   c.Query("status"),
+	}, req)
+	if err != nil {
+		listError(c, err)
+		return
+	}
+	bills := page.Data
+
+	ids := make([]uuid.UUID, 0, len(bills))
+	for _, b := range bills {
+		ids = append(ids, b.TenantID)
+	}
+	locales := tenantLocales(ids)
+	for i := range bills {
+		services.LocalizeBill(&bills[i], requestLocale(c, locales[bills[i].TenantID]))
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+func (h Handler) GetBill(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	bill, err := h.BillingService.GetBill(id)
+	if err != nil {
+		billError(c, err)
+		return
+	}
+	services.LocalizeBill(bill, requestLocale(c, tenantLocale(bill.TenantID)))
+	c.JSON(http.StatusOK, bill)
+}
+
+func (h Handler) FinalizeBill(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	bill, err := h.BillingService.FinalizeBill(id)
+	if err != nil {
+		billError(c, err)
+		return
+	}
+	services.LocalizeBill(bill, requestLocale(c, tenantLocale(bill.TenantID)))
+	c.JSON(http.StatusOK, bill)
+}
+
+func (h Handler) VoidBill(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && c.Request.ContentLength > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	bill, err := h.BillingService.VoidBill(id, req.Reason)
+	if err != nil {
+		billError(c, err)
+		return
+	}
+	services.LocalizeBill(bill, requestLocale(c, tenantLocale(bill.TenantID)))
+	c.JSON(http.StatusOK, bill)
+}
+
+func (h Handler) PayBill(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var req struct {
+		PaidAt           *time.Time `json:"paid_at"`
+		PaymentReference string     `json:"payment_reference"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && c.Request.ContentLength > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	bill, err := h.BillingService.MarkBillPaid(id, req.PaidAt, req.PaymentReference)
+	if err != nil {
+		billError(c, err)
+		return
+	}
+	services.LocalizeBill(bill, requestLocale(c, tenantLocale(bill.TenantID)))
+	c.JSON(http.StatusOK, bill)
+}
+
+func billError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrBillNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidBillTransition):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func (h Handler) GetBillingRuns(c *gin.Context) {
+	var runs []models.BillingRun
+	q := database.DB.Preload("Items").Order("started_at DESC")
+	if v := c.Query("month"); v != "" {
+		q = q.Where("month = ?", v)
+	}
+	if err := q.Limit(50).Find(&runs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, runs)
+}
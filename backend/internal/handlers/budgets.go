@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/lypolix/FaaS-billing/internal/database"
+	"github.com/lypolix/FaaS-billing/internal/models"
+	"github.com/lypolix/FaaS-billing/internal/services"
+)
+
+func (h Handler) GetTenantBudgets(c *gin.Context) {
+	tenantID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tenant id"})
+		return
+	}
+
+	var budgets []models.Budget
+	if err := database.DB.Where("tenant_id = ?", tenantID).Order("created_at").Find(&budgets).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, budgets)
+}
+
+func (h Handler) CreateBudget(c *gin.Context) {
+	tenantID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tenant id"})
+		return
+	}
+
+	var tenant models.Tenant
+	if err := database.DB.First(&tenant, "id = ?", tenantID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tenant not found"})
+		return
+	}
+
+	var b models.Budget
+	if err := c.ShouldBindJSON(&b); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	b.ID = uuid.New()
+	b.TenantID = tenantID
+	b.Active = true
+	if !validBudgetService(c, b) {
+		return
+	}
+	if err := services.ValidateBudget(&b, tenant); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := database.DB.Create(&b).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, b)
+}
+
+// UpdateBudget меняет сумму, пороги и каналы. Уже сработавшие в текущем
+// месяце пороги повторно не срабатывают.
+func (h Handler) UpdateBudget(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var b models.Budget
+	if err := database.DB.First(&b, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "budget not found"})
+		return
+	}
+	tenantID := b.TenantID
+
+	if err := c.ShouldBindJSON(&b); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	b.ID = id
+	b.TenantID = tenantID
+
+	var tenant models.Tenant
+	if err := database.DB.First(&tenant, "id = ?", tenantID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tenant not found"})
+		return
+	}
+	if !validBudgetService(c, b) {
+		return
+	}
+	if err := services.ValidateBudget(&b, tenant); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := database.DB.Save(&b).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, b)
+}
+
+// validBudgetService проверяет, что сервис бюджета принадлежит арендатору
+func validBudgetService(c *gin.Context, b models.Budget) bool {
+	if b.ServiceID == nil {
+		return true
+	}
+	var svc models.Service
+	if err := database.DB.First(&svc, "id = ? AND tenant_id = ?", *b.ServiceID, b.TenantID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "service not found for tenant"})
+		return false
+	}
+	return true
+}
+
+// GetBudgetStatus - расходы с начала месяца и прогноз, без уведомлений
+func (h Handler) GetBudgetStatus(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var b models.Budget
+	if err := database.DB.First(&b, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "budget not found"})
+		return
+	}
+
+	st, err := h.BudgetService.Status(b, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, st)
+}
+
+func (h Handler) GetBudgetAlerts(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var alerts []models.BudgetAlert
+	if err := database.DB.Where("budget_id = ?", id).Order("fired_at DESC").Find(&alerts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, alerts)
+}
+
+// EvaluateBudgets запускает проверку бюджетов вручную (?tenant_id=... - одного
+// арендатора). Периодически то же делает cmd/budget-alerts.
+func (h Handler) EvaluateBudgets(c *gin.Context) {
+	var tenantID *uuid.UUID
+	if v := c.Query("tenant_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tenant_id"})
+			return
+		}
+		tenantID = &id
+	}
+
+	statuses, err := h.BudgetService.Evaluate(time.Now(), tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, statuses)
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/lypolix/FaaS-billing/internal/database"
+	"github.com/lypolix/FaaS-billing/internal/models"
+	"github.com/lypolix/FaaS-billing/internal/services"
+)
+
+func (h Handler) GetTenantSpendingCaps(c *gin.Context) {
+	tenantID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tenant id"})
+		return
+	}
+
+	var caps []models.SpendingCap
+	if err := database.DB.Where("tenant_id = ?", tenantID).Order("created_at").Find(&caps).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, caps)
+}
+
+func (h Handler) CreateSpendingCap(c *gin.Context) {
+	tenantID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tenant id"})
+		return
+	}
+
+	var tenant models.Tenant
+	if err := database.DB.First(&tenant, "id = ?", tenantID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tenant not found"})
+		return
+	}
+
+	var sc models.SpendingCap
+	if err := c.ShouldBindJSON(&sc); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sc.ID = uuid.New()
+	sc.TenantID = tenantID
+	sc.Active = true
+	if !validCapService(c, sc) {
+		return
+	}
+	if err := services.ValidateCap(&sc, tenant); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := database.DB.Create(&sc).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, sc)
+}
+
+// UpdateSpendingCap меняет лимит и сразу перепроверяет арендатора: при
+// повышении или отключении лимита приостановка снимается без ожидания cron.
+func (h Handler) UpdateSpendingCap(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var sc models.SpendingCap
+	if err := database.DB.First(&sc, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "spending cap not found"})
+		return
+	}
+	tenantID := sc.TenantID
+
+	if err := c.ShouldBindJSON(&sc); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sc.ID = id
+	sc.TenantID = tenantID
+
+	var tenant models.Tenant
+	if err := database.DB.First(&tenant, "id = ?", tenantID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tenant not found"})
+		return
+	}
+	if !validCapService(c, sc) {
+		return
+	}
+	if err := services.ValidateCap(&sc, tenant); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := database.DB.Save(&sc).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := h.CapService.Evaluate(time.Now(), &tenantID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, sc)
+}
+
+func validCapService(c *gin.Context, sc models.SpendingCap) bool {
+	if sc.ServiceID == nil {
+		return true
+	}
+	var svc models.Service
+	if err := database.DB.First(&svc, "id = ? AND tenant_id = ?", *sc.ServiceID, sc.TenantID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "service not found for tenant"})
+		return false
+	}
+	return true
+}
+
+// EvaluateSpendingCaps запускает проверку лимитов вручную (?tenant_id=...).
+// Периодически то же делает cmd/spend-caps.
+func (h Handler) EvaluateSpendingCaps(c *gin.Context) {
+	var tenantID *uuid.UUID
+	if v := c.Query("tenant_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tenant_id"})
+			return
+		}
+		tenantID = &id
+	}
+
+	statuses, err := h.CapService.Evaluate(time.Now(), tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, statuses)
+}
+
+// GetSuspensions - действующие приостановки; этот список кэшируют
+// queue-proxy и saver, чтобы отклонять вызовы с 402
+func (h Handler) GetSuspensions(c *gin.Context) {
+	list, err := h.CapService.ActiveSuspensions(time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/lypolix/FaaS-billing/internal/services"
+)
+
+// GetTenantCosts - ряд стоимости: ?granularity=day|hour&start=&end=
+// (RFC3339, по умолчанию месяц-на-дату) &group_by=service
+func (h Handler) GetTenantCosts(c *gin.Context) {
+	tenantID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tenant id"})
+		return
+	}
+
+	q := services.CostSeriesQuery{Granularity: c.Query("granularity")}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"start", &q.Start}, {"end", &q.End}} {
+		if v := c.Query(p.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + p.name + ", expected RFC3339"})
+				return
+			}
+			*p.dst = t
+		}
+	}
+	switch c.Query("group_by") {
+	case "":
+	case "service":
+		q.GroupByService = true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "group_by must be service"})
+		return
+	}
+
+	series, err := h.BillingService.CostSeries(tenantID, q)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidCostQuery):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrTenantNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrNoPlanAssigned):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, series)
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/lypolix/FaaS-billing/internal/database"
+	"github.com/lypolix/FaaS-billing/internal/models"
+)
+
+func (h Handler) GetExchangeRates(c *gin.Context) {
+	var rates []models.ExchangeRate
+	q := database.DB
+	if v := c.Query("base"); v != "" {
+		q = q.Where("base_currency = ?", strings.ToUpper(v))
+	}
+	if v := c.Query("quote"); v != "" {
+		q = q.Where("quote_currency = ?", strings.ToUpper(v))
+	}
+	if err := q.Order("rate_date DESC, base_currency, quote_currency").Limit(500).Find(&rates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rates)
+}
+
+// Принимает один курс или массив курсов
+func (h Handler) UpsertExchangeRates(c *gin.Context) {
+	raw, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var rates []models.ExchangeRate
+	if err := json.Unmarshal(raw, &rates); err != nil {
+		var one models.ExchangeRate
+		if err := json.Unmarshal(raw, &one); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON"})
+			return
+		}
+		rates = []models.ExchangeRate{one}
+	}
+
+	if err := h.CurrencyService.UpsertRates(rates); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "ok", "count": len(rates)})
+}
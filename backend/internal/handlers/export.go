@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/lypolix/FaaS-billing/internal/database"
+	"github.com/lypolix/FaaS-billing/internal/services/export"
+)
+
+// ExportUsageAggregates выгружает агрегаты потоком:
+// ?format=csv|parquet&tenant_id=&service_id=&start_time=&end_time=&window_size=
+func (h Handler) ExportUsageAggregates(c *gin.Context) {
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+	var f export.UsageFilter
+	if !parseUUIDQuery(c, "tenant_id", &f.TenantID) || !parseUUIDQuery(c, "service_id", &f.ServiceID) ||
+		!parseTimeQuery(c, "start_time", &f.Start) || !parseTimeQuery(c, "end_time", &f.End) {
+		return
+	}
+	f.WindowSize = c.Query("window_size")
+
+	startExport(c, "usage_aggregates", format)
+	n, err := export.Usage(database.DB, f, format, c.Writer)
+	if err != nil {
+		// заголовки уже отправлены: обрываем ответ, клиент получит неполный файл
+		log.Printf("usage export failed after %d rows: %v", n, err)
+		c.Abort()
+	}
+}
+
+// ExportBillLineItems выгружает строки счетов:
+// ?format=csv|parquet&tenant_id=&status=&start_time=&end_time= (по периоду счёта)
+// &code=invocations,compute_gb_hours (стабильные коды строк)
+func (h Handler) ExportBillLineItems(c *gin.Context) {
+	format, ok := exportFormat(c)
+	if !ok {
+		return
+	}
+	f := export.LineItemFilter{Status: c.Query("status")}
+	f.Codes = export.SplitCodes(c.Query("code"))
+	if !parseUUIDQuery(c, "tenant_id", &f.TenantID) ||
+		!parseTimeQuery(c, "start_time", &f.Start) || !parseTimeQuery(c, "end_time", &f.End) {
+		return
+	}
+
+	startExport(c, "bill_line_items", format)
+	n, err := export.LineItems(database.DB, f, format, c.Writer)
+	if err != nil {
+		log.Printf("line items export failed after %d rows: %v", n, err)
+		c.Abort()
+	}
+}
+
+func exportFormat(c *gin.Context) (string, bool) {
+	format := c.DefaultQuery("format", export.FormatCSV)
+	if format != export.FormatCSV && format != export.FormatParquet {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or parquet"})
+		return "", false
+	}
+	return format, true
+}
+
+func startExport(c *gin.Context, name, format string) {
+	filename := fmt.Sprintf("%s_%s.%s", name, time.Now().UTC().Format("20060102T150405Z"), format)
+	c.Header("Content-Type", export.ContentType(format))
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Status(http.StatusOK)
+}
+
+func parseUUIDQuery(c *gin.Context, name string, dst **uuid.UUID) bool {
+	v := c.Query(name)
+	if v == "" {
+		return true
+	}
+	id, err := uuid.Parse(v)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name})
+		return false
+	}
+	*dst = &id
+	return true
+}
+
+func parseTimeQuery(c *gin.Context, name string, dst **time.Time) bool {
+	v := c.Query(name)
+	if v == "" {
+		return true
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name + ", expected RFC3339"})
+		return false
+	}
+	*dst = &t
+	return true
+}
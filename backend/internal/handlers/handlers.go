@@ -6,13 +6,23 @@ import (
 )
 
 type Handler struct {
-	BillingService *services.BillingService
-	MetricsService *services.MetricsService
+	BillingService  *services.BillingService
+	MetricsService  *services.MetricsService
+	CurrencyService *services.CurrencyService
+	CreditService   *services.CreditService
+	PlanService     *services.PlanService
+	BudgetService   *services.BudgetService
+	CapService      *services.CapService
 }
 
 func NewHandler() Handler {
 	return Handler{
-		BillingService: services.NewBillingService(database.DB),
-		MetricsService: services.NewMetricsService(database.DB),
+		BillingService:  services.NewBillingService(database.DB),
+		MetricsService:  services.NewMetricsService(database.DB),
+		CurrencyService: services.NewCurrencyService(database.DB),
+		CreditService:   services.NewCreditService(database.DB),
+		PlanService:     services.NewPlanService(database.DB),
+		BudgetService:   services.NewBudgetService(database.DB, services.NotifiersFromEnv()...),
+		CapService:      services.NewCapService(database.DB),
 	}
 }
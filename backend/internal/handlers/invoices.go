@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/lypolix/FaaS-billing/internal/database"
+	"github.com/lypolix/FaaS-billing/internal/models"
+	"github.com/lypolix/FaaS-billing/internal/services/invoice"
+)
+
+func (h Handler) GetBillInvoiceHTML(c *gin.Context) {
+	doc, ok := h.invoiceDocument(c)
+	if !ok {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := invoice.RenderHTML(&buf, doc); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
+
+func (h Handler) GetBillInvoicePDF(c *gin.Context) {
+	doc, ok := h.invoiceDocument(c)
+	if !ok {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := invoice.RenderPDF(&buf, doc); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", `inline; filename="`+doc.InvoiceNumber+`.pdf"`)
+	c.Data(http.StatusOK, "application/pdf", buf.Bytes())
+}
+
+// invoiceDocument загружает счёт и арендатора; язык берётся из ?lang=,
+// Accept-Language или настроек арендатора
+func (h Handler) invoiceDocument(c *gin.Context) (*invoice.Document, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return nil, false
+	}
+
+	bill, err := h.BillingService.GetBill(id)
+	if err != nil {
+		billError(c, err)
+		return nil, false
+	}
+
+	var tenant models.Tenant
+	if err := database.DB.First(&tenant, "id = ?", bill.TenantID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tenant not found"})
+		return nil, false
+	}
+
+	doc, err := invoice.Build(*bill, tenant, requestLocale(c, tenant.Locale))
+	if err != nil {
+		if errors.Is(err, invoice.ErrNotFinalized) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return doc, true
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/lypolix/FaaS-billing/internal/database"
+	"github.com/lypolix/FaaS-billing/internal/models"
+)
+
+// GetTenantLateWindows - окна арендатора, догруженные опоздавшими событиями
+func (h Handler) GetTenantLateWindows(c *gin.Context) {
+	tenantID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tenant id"})
+		return
+	}
+
+	var windows []models.LateWindow
+	if err := database.DB.Where("tenant_id = ?", tenantID).Order("window_start DESC").Find(&windows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, windows)
+}
+
+// GetTenantAdjustments - корректировки финализированных счетов арендатора
+func (h Handler) GetTenantAdjustments(c *gin.Context) {
+	tenantID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tenant id"})
+		return
+	}
+
+	q := database.DB.Where("tenant_id = ?", tenantID)
+	if status := c.Query("status"); status != "" {
+		q = q.Where("status = ?", status)
+	}
+	var adjs []models.UsageAdjustment
+	if err := q.Order("period_start DESC").Find(&adjs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, adjs)
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/lypolix/FaaS-billing/internal/database"
+	"github.com/lypolix/FaaS-billing/internal/i18n"
+	"github.com/lypolix/FaaS-billing/internal/models"
+)
+
+// requestLocale: ?lang=, затем Accept-Language, затем язык арендатора
+func requestLocale(c *gin.Context, tenantLocale string) string {
+	if v := c.Query("lang"); v != "" {
+		return i18n.Normalize(v)
+	}
+	if l, ok := i18n.FromAcceptLanguage(c.GetHeader("Accept-Language")); ok {
+		return l
+	}
+	return i18n.Normalize(tenantLocale)
+}
+
+// tenantLocales - языки арендаторов одним запросом
+func tenantLocales(ids []uuid.UUID) map[uuid.UUID]string {
+	var tenants []models.Tenant
+	out := make(map[uuid.UUID]string, len(ids))
+	if len(ids) == 0 {
+		return out
+	}
+	database.DB.Select("id, locale").Where("id IN ?", ids).Find(&tenants)
+	for _, t := range tenants {
+		out[t.ID] = t.Locale
+	}
+	return out
+}
+
+func tenantLocale(tenantID interface{}) string {
+	var t models.Tenant
+	if err := database.DB.Select("locale").First(&t, "id = ?", tenantID).Error; err != nil {
+		return i18n.DefaultLocale
+	}
+	return t.Locale
+}
@@ -33,9 +33,10 @@ func (h Handler) AggregateMetrics(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := h.MetricsService.AggregateMetrics(req.StartTime, req.EndTime, req.WindowSize); err != nil {
+	windows, err := h.MetricsService.AggregateMetrics(req.StartTime, req.EndTime, req.WindowSize)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "aggregation completed"})
+	c.JSON(http.StatusOK, gin.H{"message": "aggregation completed", "windows": windows})
 }
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/lypolix/FaaS-billing/internal/services"
+)
+
+// pageRequest читает limit, sort и cursor списка
+func pageRequest(c *gin.Context) (services.PageRequest, bool) {
+	req := services.PageRequest{Sort: c.Query("sort"), Cursor: c.Query("cursor")}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return req, false
+		}
+		req.Limit = n
+	}
+	return req, true
+}
+
+func listError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrInvalidPage) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
@@ -1,29 +1,127 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 
 	"github.com/lypolix/FaaS-billing/internal/database"
 	"github.com/lypolix/FaaS-billing/internal/models"
+	"github.com/lypolix/FaaS-billing/internal/services"
 )
 
 func (h Handler) GetPricingPlans(c *gin.Context) {
-	var plans []models.PricingPlan
-	q := database.DB
+	req, ok := pageRequest(c)
+	if !ok {
+		return
+	}
+	page, err := h.PlanService.ListPlans(c.Query("active") == "true", c.Query("all_versions") == "true", req)
+	if err != nil {
+		listError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+func (h Handler) CreatePricingPlan(c *gin.Context) {
+	var plan models.PricingPlan
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if plan.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
 
-	if c.Query("active") == "true" {
-		q = q.Where("active = ?", true)
+	created, err := h.PlanService.CreatePlan(plan)
+	if err != nil {
+		planError(c, err)
+		return
 	}
+	c.JSON(http.StatusCreated, created)
+}
 
-	if err := q.Order("created_at DESC").Find(&plans).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+// UpdatePricingPlan создаёт новую версию плана. Поля, не переданные в
+// запросе, берутся из последней версии; effective_from по умолчанию - сейчас.
+func (h Handler) UpdatePricingPlan(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
 
-	c.JSON(http.StatusOK, plans)
+	versions, err := h.PlanService.Versions(id)
+	if err != nil {
+		planError(c, err)
+		return
+	}
+	plan := versions[len(versions)-1]
+	plan.EffectiveFrom = time.Time{}
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := h.PlanService.UpdatePlan(id, plan)
+	if err != nil {
+		planError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, updated)
+}
+
+func (h Handler) ArchivePricingPlan(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	plan, err := h.PlanService.ArchivePlan(id)
+	if err != nil {
+		planError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, plan)
+}
+
+func (h Handler) GetPricingPlanVersions(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	versions, err := h.PlanService.Versions(id)
+	if err != nil {
+		planError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, versions)
+}
+
+func planError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrPlanNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPlanPrivate):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPlanArchived), errors.Is(err, services.ErrPlanInactive),
+		errors.Is(err, services.ErrAssignmentOrder):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPlanCurrency):
+		// счёт выставляется в валюте арендатора: нужен курс из валюты плана
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidPlanInput):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
 }
 
 func (h Handler) SetTenantPricingPlan(c *gin.Context) {
@@ -35,7 +133,8 @@ func (h Handler) SetTenantPricingPlan(c *gin.Context) {
 	}
 
 	var req struct {
-		PricingPlanID string `json:"pricing_plan_id" binding:"required"`
+		PricingPlanID string     `json:"pricing_plan_id" binding:"required"`
+		EffectiveFrom *time.Time `json:"effective_from"` // по умолчанию - сейчас
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -48,22 +147,26 @@ func (h Handler) SetTenantPricingPlan(c *gin.Context) {
 		return
 	}
 
-	var plan models.PricingPlan
-	if err := database.DB.First(&plan, "id = ?", planID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "pricing plan not found"})
+	var tenant models.Tenant
+	if err := database.DB.First(&tenant, "id = ?", tenantID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tenant not found"})
 		return
 	}
 
-	if err := database.DB.Model(&models.Tenant{}).
-		Where("id = ?", tenantID).
-		Update("pricing_plan_id", planID).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update tenant: " + err.Error()})
+	var effectiveFrom time.Time
+	if req.EffectiveFrom != nil {
+		effectiveFrom = *req.EffectiveFrom
+	}
+	assignment, err := h.PlanService.AssignPlan(tenantID, planID, effectiveFrom)
+	if err != nil {
+		planError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "pricing plan updated"})
+	c.JSON(http.StatusOK, gin.H{"message": "pricing plan updated", "assignment": assignment})
 }
 
+// GetTenantPricingPlan возвращает версию плана, действующую сейчас
 func (h Handler) GetTenantPricingPlan(c *gin.Context) {
 	tenantIDStr := c.Param("id")
 	tenantID, err := uuid.Parse(tenantIDStr)
@@ -83,11 +186,48 @@ func (h Handler) GetTenantPricingPlan(c *gin.Context) {
 		return
 	}
 
-	var plan models.PricingPlan
-	if err := database.DB.First(&plan, "id = ?", *t.PricingPlanID).Error; err != nil {
+	plan, err := h.PlanService.CurrentPlan(tenantID, time.Now())
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "pricing plan not found"})
 		return
 	}
 
 	c.JSON(http.StatusOK, plan)
 }
+
+func (h Handler) GetTenantPlanHistory(c *gin.Context) {
+	tenantID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tenant id"})
+		return
+	}
+
+	assignments, err := h.PlanService.Assignments(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, assignments)
+}
+
+// GetTenantAvailablePlans - планы, которые арендатор может выбрать
+func (h Handler) GetTenantAvailablePlans(c *gin.Context) {
+	tenantID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tenant id"})
+		return
+	}
+
+	var tenant models.Tenant
+	if err := database.DB.First(&tenant, "id = ?", tenantID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tenant not found"})
+		return
+	}
+
+	plans, err := h.PlanService.AvailablePlans(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, plans)
+}
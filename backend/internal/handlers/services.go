@@ -8,6 +8,7 @@ import (
 
 	"github.com/lypolix/FaaS-billing/internal/database"
 	"github.com/lypolix/FaaS-billing/internal/models"
+	"github.com/lypolix/FaaS-billing/internal/services"
 )
 
 func (h Handler) CreateService(c *gin.Context) {
@@ -27,16 +28,26 @@ func (h Handler) CreateService(c *gin.Context) {
 }
 
 func (h Handler) GetServices(c *gin.Context) {
-	var list []models.Service
-	q := database.DB
+	req, ok := pageRequest(c)
+	if !ok {
+		return
+	}
+	q := database.DB.Model(&models.Service{})
 
 	if v := c.Query("tenant_id"); v != "" {
 		q = q.Where("tenant_id = ?", v)
 	}
 
-	if err := q.Find(&list).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	page, err := services.Paginate(q, req, services.ServiceListSpec,
+		func(s models.Service, sort string) (interface{}, interface{}) {
+			if sort == "name" {
+				return s.Name, s.ID
+			}
+			return s.CreatedAt, s.ID
+		})
+	if err != nil {
+		listError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, list)
+	c.JSON(http.StatusOK, page)
 }
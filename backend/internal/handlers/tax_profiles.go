@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/lypolix/FaaS-billing/internal/database"
+	"github.com/lypolix/FaaS-billing/internal/models"
+)
+
+func (h Handler) GetTaxProfiles(c *gin.Context) {
+	var profiles []models.TaxProfile
+	q := database.DB
+	if c.Query("active") == "true" {
+		q = q.Where("active = ?", true)
+	}
+	if err := q.Order("code").Find(&profiles).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, profiles)
+}
+
+func (h Handler) CreateTaxProfile(c *gin.Context) {
+	var p models.TaxProfile
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if p.Code == "" || p.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code and name are required"})
+		return
+	}
+	if p.Mode == "" {
+		p.Mode = models.TaxModeStandard
+	}
+	switch p.Mode {
+	case models.TaxModeStandard:
+		if p.Rate <= 0 || p.Rate >= 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "rate must be a fraction between 0 and 1, e.g. 0.2"})
+			return
+		}
+	case models.TaxModeExempt, models.TaxModeReverseCharge:
+		p.Rate = 0
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be one of: standard, exempt, reverse_charge"})
+		return
+	}
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	p.Active = true
+
+	if err := database.DB.Create(&p).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, p)
+}
+
+func (h Handler) SetTenantTaxProfile(c *gin.Context) {
+	tenantID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tenant id"})
+		return
+	}
+
+	// null снимает профиль с арендатора
+	var req struct {
+		TaxProfileID *string `json:"tax_profile_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var profileID *uuid.UUID
+	if req.TaxProfileID != nil {
+		id, err := uuid.Parse(*req.TaxProfileID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tax_profile_id"})
+			return
+		}
+		var p models.TaxProfile
+		if err := database.DB.First(&p, "id = ? AND active = ?", id, true).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "tax profile not found or inactive"})
+			return
+		}
+		profileID = &id
+	}
+
+	res := database.DB.Model(&models.Tenant{}).
+		Where("id = ?", tenantID).
+		Update("tax_profile_id", profileID)
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update tenant: " + res.Error.Error()})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tenant not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "tax profile updated"})
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/lypolix/FaaS-billing/internal/database"
 	"github.com/lypolix/FaaS-billing/internal/models"
+	"github.com/lypolix/FaaS-billing/internal/services"
 )
 
 func (h Handler) CreateTenant(c *gin.Context) {
@@ -19,6 +20,24 @@ func (h Handler) CreateTenant(c *gin.Context) {
 	if t.ID == uuid.Nil {
 		t.ID = uuid.New()
 	}
+	// префикс номеров счетов; без него назначается при первой финализации
+	if t.InvoicePrefix != nil {
+		prefix, err := services.NormalizeInvoicePrefix(*t.InvoicePrefix)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var taken int64
+		if err := database.DB.Model(&models.Tenant{}).Where("invoice_prefix = ?", prefix).Count(&taken).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if taken > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": services.ErrInvoicePrefixTaken.Error()})
+			return
+		}
+		t.InvoicePrefix = &prefix
+	}
 	if err := database.DB.Create(&t).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -27,12 +46,22 @@ func (h Handler) CreateTenant(c *gin.Context) {
 }
 
 func (h Handler) GetTenants(c *gin.Context) {
-	var tenants []models.Tenant
-	if err := database.DB.Find(&tenants).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	req, ok := pageRequest(c)
+	if !ok {
+		return
+	}
+	page, err := services.Paginate(database.DB.Model(&models.Tenant{}), req, services.TenantListSpec,
+		func(t models.Tenant, sort string) (interface{}, interface{}) {
+			if sort == "name" {
+				return t.Name, t.ID
+			}
+			return t.CreatedAt, t.ID
+		})
+	if err != nil {
+		listError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, tenants)
+	c.JSON(http.StatusOK, page)
 }
 
 func (h Handler) GetTenant(c *gin.Context) {
@@ -1,18 +1,25 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 
 	"github.com/lypolix/FaaS-billing/internal/database"
 	"github.com/lypolix/FaaS-billing/internal/models"
+	"github.com/lypolix/FaaS-billing/internal/services"
 )
 
 func (h Handler) GetUsageAggregates(c *gin.Context) {
-	var aggs []models.UsageAggregate
-	q := database.DB
+	req, ok := pageRequest(c)
+	if !ok {
+		return
+	}
+	q := database.DB.Model(&models.UsageAggregate{})
 
 	if s := c.Query("start_time"); s != "" {
 		if t, err := time.Parse(time.RFC3339, s); err == nil {
@@ -30,11 +37,72 @@ func (h Handler) GetUsageAggregates(c *gin.Context) {
 	if v := c.Query("service_id"); v != "" {
 		q = q.Where("service_id = ?", v)
 	}
+	if v := c.Query("window_size"); v != "" {
+		q = q.Where("window_size = ?", v)
+	}
+	if v := c.Query("revision_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid revision_id"})
+			return
+		}
+		q = q.Where("revision_id = ?", id)
+	}
 
-	if err := q.Order("window_start DESC").Find(&aggs).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	page, err := services.Paginate(q, req, services.UsageAggregateListSpec,
+		func(a models.UsageAggregate, _ string) (interface{}, interface{}) {
+			return a.WindowStart, a.ID
+		})
+	if err != nil {
+		listError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// QueryUsage - ряд использования по интервалам:
+// ?start=&end= (RFC3339) &step=1h|1d &group_by=tenant,service,revision,label:<key>
+// &measures=invocations,gb_hours,p95,cold_starts,egress,errors
+// &tenant_id=&service_id=&revision_id=
+func (h Handler) QueryUsage(c *gin.Context) {
+	var q services.UsageQuery
+	var start, end *time.Time
+	if !parseTimeQuery(c, "start", &start) || !parseTimeQuery(c, "end", &end) ||
+		!parseUUIDQuery(c, "tenant_id", &q.TenantID) ||
+		!parseUUIDQuery(c, "service_id", &q.ServiceID) ||
+		!parseUUIDQuery(c, "revision_id", &q.RevisionID) {
 		return
 	}
+	if start == nil || end == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start and end are required"})
+		return
+	}
+	q.Start, q.End = *start, *end
 
-	c.JSON(http.StatusOK, gin.H{"data": aggs})
+	if v := c.Query("step"); v != "" {
+		step, err := services.ParseStep(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		q.Step = step
+	}
+	if v := c.Query("group_by"); v != "" {
+		q.GroupBy = strings.Split(v, ",")
+	}
+	if v := c.Query("measures"); v != "" {
+		q.Measures = strings.Split(v, ",")
+	}
+
+	series, err := h.MetricsService.QueryUsage(q)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidUsageQuery) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, series)
 }
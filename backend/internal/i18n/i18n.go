@@ -0,0 +1,206 @@
+package i18n
+
+import (
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+const (
+	RU = "ru"
+	EN = "en"
+
+	DefaultLocale = RU
+)
+
+// Каталог сообщений: locale -> ключ -> текст
+var catalog = map[string]map[string]string{
+	RU: {
+		"invoice.title":          "Счёт",
+		"invoice.number":         "Номер счёта",
+		"invoice.status":         "Статус",
+		"invoice.issued":         "Дата выставления",
+		"invoice.period":         "Период",
+		"invoice.bill_to":        "Плательщик",
+		"invoice.email":          "Email",
+		"invoice.description":    "Наименование",
+		"invoice.quantity":       "Количество",
+		"invoice.free_tier_used": "Бесплатно",
+		"invoice.billable":       "К оплате (кол-во)",
+		"invoice.unit_price":     "Цена",
+		"invoice.amount":         "Сумма",
+		"invoice.subtotal":       "Итого без налога",
+		"invoice.tax":            "НДС",
+		"invoice.total":          "Итого к оплате",
+		"invoice.free_tier":      "Бесплатный лимит",
+		"invoice.used_of_limit":  "использовано %s из %s",
+		"invoice.exchange_note":  "Пересчёт по курсу 1 %s = %s %s на %s",
+		"invoice.balance_note":   "Остаток кредитов на балансе: %s %s",
+
+		"free_tier.invocations": "Вызовы",
+		"free_tier.gb_hours":    "ГБ×час",
+		"free_tier.egress_gb":   "Исходящий трафик, ГБ",
+		"free_tier.cold_starts": "Холодные старты",
+		"free_tier.vcpu_hours":  "vCPU×час",
+
+		"tax.none":                "Без налога",
+		"tax.exempt":              "Без НДС",
+		"tax.exempt_note":         "НДС не облагается",
+		"tax.reverse_charge":      "НДС (обратное начисление)",
+		"tax.reverse_charge_note": "НДС исчисляется покупателем",
+		"tax.inclusive_note":      "Цены указаны с учётом НДС",
+
+		"status.draft": "черновик",
+		"status.final": "выставлен",
+		"status.paid":  "оплачен",
+		"status.void":  "аннулирован",
+
+		"line_item.invocations":                "Вызовы функций",
+		"line_item.compute_gb_hours":           "Время выполнения функций (ГБ×час)",
+		"line_item.egress_gb":                  "Исходящий трафик",
+		"line_item.vcpu_hours":                 "Процессорное время (vCPU×час)",
+		"line_item.cold_starts":                "Холодные старты",
+		"line_item.cold_start_init_gb_seconds": "Инициализация при холодном старте (ГБ×с)",
+		"line_item.credits":                    "Списание кредитов",
+		"line_item.discount":                   "Скидка по контракту",
+		"line_item.commit_true_up":             "Доплата до минимального платежа",
+		"line_item.late_usage_adjustment":      "Доначисление за использование прошлого периода",
+	},
+	EN: {
+		"invoice.title":          "Invoice",
+		"invoice.number":         "Invoice number",
+		"invoice.status":         "Status",
+		"invoice.issued":         "Issue date",
+		"invoice.period":         "Billing period",
+		"invoice.bill_to":        "Bill to",
+		"invoice.email":          "Email",
+		"invoice.description":    "Description",
+		"invoice.quantity":       "Quantity",
+		"invoice.free_tier_used": "Free tier",
+		"invoice.billable":       "Billable",
+		"invoice.unit_price":     "Unit price",
+		"invoice.amount":         "Amount",
+		"invoice.subtotal":       "Subtotal",
+		"invoice.tax":            "VAT",
+		"invoice.total":          "Total due",
+		"invoice.free_tier":      "Free tier",
+		"invoice.used_of_limit":  "%s used of %s",
+		"invoice.exchange_note":  "Converted at 1 %s = %s %s as of %s",
+		"invoice.balance_note":   "Remaining credit balance: %s %s",
+
+		"free_tier.invocations": "Invocations",
+		"free_tier.gb_hours":    "GB-hours",
+		"free_tier.egress_gb":   "Egress, GB",
+		"free_tier.cold_starts": "Cold starts",
+		"free_tier.vcpu_hours":  "vCPU-hours",
+
+		"tax.none":                "No tax",
+		"tax.exempt":              "VAT exempt",
+		"tax.exempt_note":         "Exempt from VAT",
+		"tax.reverse_charge":      "VAT (reverse charge)",
+		"tax.reverse_charge_note": "Reverse charge: VAT to be accounted for by the recipient",
+		"tax.inclusive_note":      "Prices include VAT",
+
+		"status.draft": "draft",
+		"status.final": "issued",
+		"status.paid":  "paid",
+		"status.void":  "void",
+
+		"line_item.invocations":                "Function invocations",
+		"line_item.compute_gb_hours":           "Function compute time (GB-hours)",
+		"line_item.egress_gb":                  "Outbound traffic",
+		"line_item.vcpu_hours":                 "CPU time (vCPU-hours)",
+		"line_item.cold_starts":                "Cold starts",
+		"line_item.cold_start_init_gb_seconds": "Cold start initialization (GB-seconds)",
+		"line_item.credits":                    "Credits applied",
+		"line_item.discount":                   "Contract discount",
+		"line_item.commit_true_up":             "Minimum commitment true-up",
+		"line_item.late_usage_adjustment":      "Late usage adjustment for a previous period",
+	},
+}
+
+// T возвращает перевод ключа; при отсутствии перевода - вариант на
+// локали по умолчанию, затем сам ключ
+func T(locale, key string) string {
+	if m, ok := catalog[Normalize(locale)]; ok {
+		if v, ok := m[key]; ok {
+			return v
+		}
+	}
+	if v, ok := catalog[DefaultLocale][key]; ok {
+		return v
+	}
+	return key
+}
+
+// Normalize приводит "en-US", "EN", "ru_RU" к поддерживаемой локали
+func Normalize(locale string) string {
+	l := strings.ToLower(strings.TrimSpace(locale))
+	if i := strings.IndexAny(l, "-_"); i > 0 {
+		l = l[:i]
+	}
+	if _, ok := catalog[l]; ok {
+		return l
+	}
+	return DefaultLocale
+}
+
+// Supported сообщает, есть ли каталог для локали (без fallback)
+func Supported(locale string) bool {
+	l := strings.ToLower(strings.TrimSpace(locale))
+	if i := strings.IndexAny(l, "-_"); i > 0 {
+		l = l[:i]
+	}
+	_, ok := catalog[l]
+	return ok
+}
+
+// Коды строк счёта для описаний, сохранённых до появления кодов
+var legacyLineItems = map[string]string{
+	"Вызовы функций":                    "invocations",
+	"Время выполнения функций (ГБ×час)": "compute_gb_hours",
+	"Исходящий трафик":                  "egress_gb",
+	"Холодные старты":                   "cold_starts",
+}
+
+// LineItemDescription возвращает описание строки счёта по коду. Для строк,
+// сохранённых без кода, код восстанавливается по русскому описанию;
+// неизвестные строки возвращаются как есть
+func LineItemDescription(locale, code, description string) string {
+	if code == "" {
+		code = legacyLineItems[description]
+	}
+	key := "line_item." + code
+	if code == "" || T(locale, key) == key {
+		return description
+	}
+	return T(locale, key)
+}
+
+// LineItemLabel - описание строки с указанием статьи, к которой она
+// относится (например, скидка по вызовам)
+func LineItemLabel(locale, code, appliesTo, description string) string {
+	if appliesTo == "" {
+		return LineItemDescription(locale, code, description)
+	}
+	return T(locale, "line_item."+code) + ": " + LineItemDescription(locale, appliesTo, appliesTo)
+}
+
+// FromAcceptLanguage выбирает первую поддерживаемую локаль из заголовка
+// Accept-Language с учётом весов q
+func FromAcceptLanguage(header string) (string, bool) {
+	if header == "" {
+		return "", false
+	}
+	tags, _, err := language.ParseAcceptLanguage(header)
+	if err != nil {
+		return "", false
+	}
+	for _, tag := range tags {
+		base, _ := tag.Base()
+		if Supported(base.String()) {
+			return base.String(), true
+		}
+	}
+	return "", false
+}
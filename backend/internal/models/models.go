@@ -3,11 +3,15 @@ package models
 import (
 	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
+var ErrLedgerAppendOnly = errors.New("balance ledger is append-only")
+
 type JSONB map[string]interface{}
 
 func (j JSONB) Value() (driver.Value, error) {
@@ -23,13 +27,16 @@ func (j *JSONB) Scan(value interface{}) error {
 }
 
 type Tenant struct {
-	ID           uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
-	Name         string    `json:"name" gorm:"not null"`
-	BillingEmail string    `json:"billing_email"`
-	Currency     string    `json:"currency" gorm:"default:'RUB'"`
-	Timezone     string    `json:"timezone" gorm:"default:'UTC'"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID            uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Name          string     `json:"name" gorm:"not null"`
+	BillingEmail  string     `json:"billing_email"`
+	Currency      string     `json:"currency" gorm:"default:'RUB'"`
+	Timezone      string     `json:"timezone" gorm:"default:'UTC'"`
+	Locale        string     `json:"locale" gorm:"default:'ru'"`                          // язык счетов: ru, en
+	InvoicePrefix *string    `json:"invoice_prefix,omitempty" gorm:"size:12;uniqueIndex"` // INV-<префикс>-000042; без него назначается из ID
+	TaxProfileID  *uuid.UUID `json:"tax_profile_id" gorm:"type:uuid"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
 
 	PricingPlanID *uuid.UUID   `json:"pricing_plan_id" gorm:"type:uuid"`
     PricingPlan   *PricingPlan `json:"pricing_plan" gorm:"foreignKey:PricingPlanID"`
@@ -47,6 +54,7 @@ type Service struct {
 	ArtifactName string `json:"artifact_name"`
 	ArtifactSize int64  `json:"artifact_size"`
 	ArtifactSHA  string `json:"artifact_sha256"`
+	Labels        JSONB     `json:"labels,omitempty" gorm:"type:jsonb"` // метки для группировки использования (label:<key>)
 	CreatedAt     time.Time `json:"created_at"`
 	
 	Tenant Tenant `json:"tenant" gorm:"foreignKey:TenantID"`
@@ -94,6 +102,7 @@ type UsageAggregate struct {
 	// Основные метрики
 	Invocations      int64   `json:"invocations"`
 	TotalDurationMS  int64   `json:"total_duration_ms"`
+	BillableDurationMS int64 `json:"billable_duration_ms"` // с учётом минимума и округления плана
 	AvgDurationMS    float64 `json:"avg_duration_ms"`
 	P50DurationMS    float64 `json:"p50_duration_ms"`
 	P95DurationMS    float64 `json:"p95_duration_ms"`
@@ -102,17 +111,109 @@ type UsageAggregate struct {
 	MaxMemoryMB      float64 `json:"max_memory_mb"`
 	AvgMemoryMB      float64 `json:"avg_memory_mb"`
 	TotalMemoryMBHours float64 `json:"total_memory_mb_hours"` // ключевое для биллинга
+	MemorySamples    int64   `json:"memory_samples"` // число замеров памяти: вес средней при догрузке опоздавших событий
 	
 	// Дополнительные метрики
 	ColdStarts       int     `json:"cold_starts"`
+	ColdStartInitMS    int64   `json:"cold_start_init_ms"`    // суммарное время инициализации
+	ColdStartGBSeconds float64 `json:"cold_start_gb_seconds"` // инициализация в ГБ×с
 	Errors           int     `json:"errors"`
 	EgressBytes      int64   `json:"egress_bytes"` // исходящий трафик
+	TotalCPUMS       int64   `json:"total_cpu_ms"` // процессорное время, мс (метрика cpu_ms)
 	
 	Tenant   Tenant   `json:"tenant" gorm:"foreignKey:TenantID"`
 	Service  Service  `json:"service" gorm:"foreignKey:ServiceID"`
 	Revision *Revision `json:"revision" gorm:"foreignKey:RevisionID"`
 }
 
+// AggregationWindow - отметка о завершённом окне агрегации. Source пуст для
+// окон, посчитанных из usage_raws; у свёрток это размер окон-источников
+// (1h из 1m, 1d из 1h), и строки источников внутри такого окна заменены им.
+type AggregationWindow struct {
+	WindowSize  string    `json:"window_size" gorm:"primaryKey"`
+	WindowStart time.Time `json:"window_start" gorm:"primaryKey"`
+	WindowEnd   time.Time `json:"window_end" gorm:"index"`
+	Source      string    `json:"source"`
+	Rows        int       `json:"rows"` // записей usage_aggregates в окне
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// AggregationCheckpoint - докуда непрерывный агрегатор посчитал окна размера:
+// все окна, закончившиеся не позже WindowEnd, посчитаны (или были отмечены
+// раньше другим запуском)
+type AggregationCheckpoint struct {
+	WindowSize string    `json:"window_size" gorm:"primaryKey"`
+	WindowEnd  time.Time `json:"window_end"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// LateWindow - окно, в которое пришли события после его агрегации.
+// Опоздавшие события досчитываются в агрегаты окна и его свёрток; если окно
+// попало в финализированный счёт, разница оформляется корректировкой.
+type LateWindow struct {
+	ID             uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	WindowSize     string     `json:"window_size" gorm:"uniqueIndex:idx_late_window"`
+	WindowStart    time.Time  `json:"window_start" gorm:"uniqueIndex:idx_late_window"`
+	WindowEnd      time.Time  `json:"window_end"`
+	TenantID       uuid.UUID  `json:"tenant_id" gorm:"type:uuid;uniqueIndex:idx_late_window"`
+	Events         int64      `json:"events"` // опоздавших сырых записей за всё время
+	Reaggregations int        `json:"reaggregations"`
+	BillID         *uuid.UUID `json:"bill_id,omitempty" gorm:"type:uuid"`       // финализированный счёт периода окна
+	AdjustmentID   *uuid.UUID `json:"adjustment_id,omitempty" gorm:"type:uuid"` // корректировка по этому счёту
+	FirstSeenAt    time.Time  `json:"first_seen_at"`
+	ReaggregatedAt time.Time  `json:"reaggregated_at"`
+}
+
+// Статусы корректировки
+const (
+	AdjustmentPending = "pending" // войдёт в следующий счёт
+	AdjustmentApplied = "applied" // включена в финализированный счёт
+	AdjustmentVoid    = "void"    // разница исчезла до применения
+)
+
+// UsageAdjustment - доначисление за использование, учтённое после
+// финализации счёта BillID. Финальный счёт не меняется: сумма выставляется
+// строкой late_usage_adjustment в следующем счёте арендатора.
+type UsageAdjustment struct {
+	ID            uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	TenantID      uuid.UUID  `json:"tenant_id" gorm:"type:uuid;not null;index"`
+	BillID        uuid.UUID  `json:"bill_id" gorm:"type:uuid;not null;index"` // счёт, период которого исправляется
+	PeriodStart   time.Time  `json:"period_start"`
+	PeriodEnd     time.Time  `json:"period_end"`
+	Amount        float64    `json:"amount"` // без налога, после скидок контракта
+	Currency      string     `json:"currency" gorm:"size:3"`
+	Status        string     `json:"status" gorm:"default:'pending';index"`
+	AppliedBillID *uuid.UUID `json:"applied_bill_id,omitempty" gorm:"type:uuid"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// CanonicalAggregates оставляет агрегаты, не покрытые свёрткой более
+// крупного окна: сумма по ним не учитывает одно использование дважды
+func CanonicalAggregates(db *gorm.DB) *gorm.DB {
+	return db.Where(`NOT EXISTS (
+		SELECT 1 FROM aggregation_windows w
+		WHERE w.source <> ''
+		AND w.window_start <= usage_aggregates.window_start
+		AND w.window_end >= usage_aggregates.window_end
+		AND w.window_end - w.window_start > usage_aggregates.window_end - usage_aggregates.window_start)`)
+}
+
+// CanonicalAggregatesWithin - то же среди окон не длиннее max: для рядов
+// с шагом max, где строка крупнее шага попала бы целиком в один интервал
+func CanonicalAggregatesWithin(max time.Duration) func(*gorm.DB) *gorm.DB {
+	seconds := max.Seconds()
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(`EXTRACT(EPOCH FROM usage_aggregates.window_end - usage_aggregates.window_start) <= ? AND NOT EXISTS (
+			SELECT 1 FROM aggregation_windows w
+			WHERE w.source <> ''
+			AND EXTRACT(EPOCH FROM w.window_end - w.window_start) <= ?
+			AND w.window_start <= usage_aggregates.window_start
+			AND w.window_end >= usage_aggregates.window_end
+			AND w.window_end - w.window_start > usage_aggregates.window_end - usage_aggregates.window_start)`, seconds, seconds)
+	}
+}
+
 // Тарифный план по модели Yandex Cloud
 type PricingPlan struct {
 	ID                     uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
@@ -123,8 +224,10 @@ type PricingPlan struct {
 	// Основные цены (базируются на Yandex Cloud)
 	PricePerMillionInvocations float64 `json:"price_per_million_invocations"` // 17.28 ₽
 	PricePerGBHour            float64 `json:"price_per_gb_hour"`             // 5.9076 ₽
-	PricePerColdStart         float64 `json:"price_per_cold_start"`          // 0 (пока не тарифицируется)
+	PricePerColdStart         float64 `json:"price_per_cold_start"`          // за каждый холодный старт сверх free tier
+	PricePerColdStartGBSecond float64 `json:"price_per_cold_start_gb_second"` // надбавка за время инициализации, 0 = нет
 	PricePerGBEgress          float64 `json:"price_per_gb_egress"`           // 1.6524 ₽
+	PricePerVCPUHour          float64 `json:"price_per_vcpu_hour"`           // процессорное время отдельно от памяти; 0 = не тарифицируется
 	
 	// Дополнительные опции
 	PricePerGBHourProvisioned float64 `json:"price_per_gb_hour_provisioned"` // 1.296 ₽ (время простоя)
@@ -134,28 +237,331 @@ type PricingPlan struct {
 	FreeTierInvocations    int64   `json:"free_tier_invocations"`     // 1,000,000
 	FreeTierGBHours        float64 `json:"free_tier_gb_hours"`        // 10.0
 	FreeTierEgressGB       float64 `json:"free_tier_egress_gb"`       // 100.0
+	FreeTierColdStarts     int64   `json:"free_tier_cold_starts"`
+	FreeTierVCPUHours      float64 `json:"free_tier_vcpu_hours"`
 	
+	// Цены плана указаны с налогом (true) или без (false)
+	PricesIncludeTax bool `json:"prices_include_tax"`
+
+	// Учёт памяти: measured - по замерам memory_mb, allocated - лимит памяти
+	// сервиса × длительность вызовов с минимумом и округлением вверх
+	MemoryBillingMode     string `json:"memory_billing_mode" gorm:"default:'measured'"`
+	MinBillableDurationMS int    `json:"min_billable_duration_ms"` // минимальная длительность вызова
+	DurationRoundingMS    int    `json:"duration_rounding_ms"`     // шаг округления вверх; 0 = 1 мс
+
+	// Версионирование: правка плана создаёт новую неизменяемую версию
+	// с тем же GroupID и датой вступления в силу EffectiveFrom
+	GroupID       uuid.UUID  `json:"group_id" gorm:"type:uuid;uniqueIndex:idx_plan_group_version"`
+	Version       int        `json:"version" gorm:"default:1;uniqueIndex:idx_plan_group_version"`
+	EffectiveFrom time.Time  `json:"effective_from"`
+	ArchivedAt    *time.Time `json:"archived_at"`
+
 	Active     bool      `json:"active" gorm:"default:true"`
 	CreatedAt  time.Time `json:"created_at"`
 	
 	Tenant *Tenant `json:"tenant" gorm:"foreignKey:TenantID"`
 }
 
-type Bill struct {
+// Новый план без GroupID открывает собственное семейство версий
+func (p *PricingPlan) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	if p.GroupID == uuid.Nil {
+		p.GroupID = p.ID
+	}
+	if p.Version == 0 {
+		p.Version = 1
+	}
+	if p.EffectiveFrom.IsZero() {
+		p.EffectiveFrom = time.Now()
+	}
+	return nil
+}
+
+// Способы учёта памяти в плане
+const (
+	MemoryBillingMeasured  = "measured"
+	MemoryBillingAllocated = "allocated"
+)
+
+// Назначение плана арендатору. Арендатор привязывается к семейству версий
+// (GroupID): в каждый момент действует версия с последней EffectiveFrom.
+type TenantPlanAssignment struct {
+	ID            uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	TenantID      uuid.UUID  `json:"tenant_id" gorm:"type:uuid;not null;index"`
+	PlanGroupID   uuid.UUID  `json:"plan_group_id" gorm:"type:uuid;not null"`
+	EffectiveFrom time.Time  `json:"effective_from" gorm:"not null"`
+	EffectiveTo   *time.Time `json:"effective_to"` // не включительно; nil = действует сейчас
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// Курс валюты: 1 BaseCurrency = Rate QuoteCurrency на дату RateDate
+type ExchangeRate struct {
+	ID            uint      `json:"id" gorm:"primary_key"`
+	BaseCurrency  string    `json:"base" gorm:"size:3;not null;uniqueIndex:idx_exchange_rate_pair_date"`
+	QuoteCurrency string    `json:"quote" gorm:"size:3;not null;uniqueIndex:idx_exchange_rate_pair_date"`
+	RateDate      time.Time `json:"date" gorm:"type:date;not null;uniqueIndex:idx_exchange_rate_pair_date"`
+	Rate          float64   `json:"rate" gorm:"not null"`
+	Source        string    `json:"source"` // file, api
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Контракт с арендатором: скидки и минимальный ежемесячный платёж
+// (committed use). Скидка по статье из DimensionDiscounts заменяет общую
+// DiscountPercent.
+type Contract struct {
+	ID                 uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	TenantID           uuid.UUID  `json:"tenant_id" gorm:"type:uuid;not null;index"`
+	Name               string     `json:"name"`
+	DiscountPercent    float64    `json:"discount_percent"`                      // 0..100
+	DimensionDiscounts JSONB      `json:"dimension_discounts" gorm:"type:jsonb"` // код строки -> процент
+	MinimumCommit      float64    `json:"minimum_commit"`                        // минимум за месяц после скидок, без налога
+	Currency           string     `json:"currency" gorm:"size:3"`                // валюта минимального платежа = валюта арендатора
+	ValidFrom          time.Time  `json:"valid_from" gorm:"not null"`
+	ValidTo            *time.Time `json:"valid_to"` // не включительно; nil = бессрочно
+	Active             bool       `json:"active" gorm:"default:true"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+// Виды записей журнала баланса арендатора
+const (
+	LedgerPromoCredit    = "promo_credit"    // промо-кредит (в т.ч. по промокоду)
+	LedgerTopUp          = "top_up"          // предоплата
+	LedgerAdjustment     = "adjustment"      // ручная корректировка
+	LedgerCreditApplied  = "credit_applied"  // списание в счёт
+	LedgerCreditReversal = "credit_reversal" // возврат списания при аннулировании счёта
+)
+
+// Запись журнала баланса. Журнал только дополняется: записи не
+// изменяются и не удаляются. Положительная запись без GrantID - начисление
+// (grant), остальные записи ссылаются на начисление, из которого списаны
+// или в которое возвращены средства.
+type BalanceEntry struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	TenantID    uuid.UUID  `json:"tenant_id" gorm:"type:uuid;not null;index"`
+	Kind        string     `json:"kind" gorm:"not null"`
+	Amount      float64    `json:"amount"` // > 0 начисление, < 0 списание
+	Currency    string     `json:"currency" gorm:"size:3"`
+	Dimensions  string     `json:"dimensions,omitempty"` // коды строк через запятую; пусто = любые
+	ExpiresAt   *time.Time `json:"expires_at"`
+	GrantID     *uuid.UUID `json:"grant_id" gorm:"type:uuid;index"`
+	BillID      *uuid.UUID `json:"bill_id" gorm:"type:uuid;index"`
+	PromoCodeID *uuid.UUID `json:"promo_code_id" gorm:"type:uuid"`
+	Note        string     `json:"note,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type PromoCode struct {
+	ID                 uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Code               string     `json:"code" gorm:"uniqueIndex;not null"`
+	Amount             float64    `json:"amount"`
+	Currency           string     `json:"currency" gorm:"size:3"`
+	Dimensions         string     `json:"dimensions,omitempty"`
+	RedeemBy           *time.Time `json:"redeem_by"`            // крайний срок активации
+	CreditValidityDays int        `json:"credit_validity_days"` // срок жизни кредита после активации; 0 = бессрочно
+	MaxRedemptions     int        `json:"max_redemptions"`      // 0 = без ограничений
+	Redemptions        int        `json:"redemptions"`
+	Active             bool       `json:"active" gorm:"default:true"`
+	CreatedAt          time.Time  `json:"created_at"`
+}
+
+// Виды порогов бюджета
+const (
+	BudgetAlertActual   = "actual"   // фактические расходы с начала месяца
+	BudgetAlertForecast = "forecast" // прогноз расходов на конец месяца
+)
+
+// Месячный бюджет арендатора: на все сервисы или на один (ServiceID).
+// Пороги - проценты от Amount через запятую.
+type Budget struct {
+	ID                 uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	TenantID           uuid.UUID  `json:"tenant_id" gorm:"type:uuid;not null;index"`
+	ServiceID          *uuid.UUID `json:"service_id" gorm:"type:uuid;index"` // nil = весь арендатор
+	Name               string     `json:"name"`
+	Amount             float64    `json:"amount"`                        // сумма на месяц, без налога
+	Currency           string     `json:"currency" gorm:"size:3"`        // = валюта арендатора
+	ActualThresholds   string     `json:"actual_thresholds"`             // например "50,80,100"
+	ForecastThresholds string     `json:"forecast_thresholds"`           // например "100"
+	Channels           string     `json:"channels" gorm:"default:'log'"` // log, webhook, email через запятую
+	WebhookURL         string     `json:"webhook_url,omitempty"`         // иначе BUDGET_WEBHOOK_URL
+	Active             bool       `json:"active" gorm:"default:true"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+// Сработавший порог бюджета. Уникален в пределах месяца, поэтому каждый
+// порог срабатывает не более одного раза за период.
+type BudgetAlert struct {
 	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
-	TenantID    uuid.UUID `json:"tenant_id" gorm:"not null"`
-	PeriodStart time.Time `json:"period_start"`
-	PeriodEnd   time.Time `json:"period_end"`
-	TotalAmount float64   `json:"total_amount"`
-	Currency    string    `json:"currency"`
-	Status      string    `json:"status" gorm:"default:'draft'"` // draft, final, paid
-	LineItems   JSONB     `json:"line_items" gorm:"type:jsonb"`
-	CreatedAt   time.Time `json:"created_at"`
-	
+	BudgetID    uuid.UUID `json:"budget_id" gorm:"type:uuid;not null;uniqueIndex:idx_budget_alert_once"`
+	PeriodStart time.Time `json:"period_start" gorm:"not null;uniqueIndex:idx_budget_alert_once"`
+	Kind        string    `json:"kind" gorm:"not null;uniqueIndex:idx_budget_alert_once"`
+	Threshold   int       `json:"threshold" gorm:"not null;uniqueIndex:idx_budget_alert_once"` // процент
+	Amount      float64   `json:"amount"`                                                      // расходы или прогноз на момент срабатывания
+	Currency    string    `json:"currency" gorm:"size:3"`
+	Channels    string    `json:"channels"` // каналы, через которые ушло уведомление
+	FiredAt     time.Time `json:"fired_at"`
+}
+
+
+// Жёсткий лимит расходов за месяц: при превышении арендатор (или один
+// сервис) приостанавливается до повышения лимита или нового месяца
+type SpendingCap struct {
+	ID        uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	TenantID  uuid.UUID  `json:"tenant_id" gorm:"type:uuid;not null;index"`
+	ServiceID *uuid.UUID `json:"service_id" gorm:"type:uuid;index"` // nil = весь арендатор
+	Limit     float64    `json:"limit"`                             // без налога, как расходы бюджета
+	Currency  string     `json:"currency" gorm:"size:3"`
+	Active    bool       `json:"active" gorm:"default:true"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// Приостановка по лимиту. Действует, пока ResumedAt пуст и не кончился
+// месяц (PeriodEnd).
+type Suspension struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	CapID       uuid.UUID  `json:"cap_id" gorm:"type:uuid;not null;index"`
+	TenantID    uuid.UUID  `json:"tenant_id" gorm:"type:uuid;not null;index"`
+	ServiceID   *uuid.UUID `json:"service_id" gorm:"type:uuid"`
+	ServiceName string     `json:"service_name,omitempty"` // имя сервиса для data plane
+	PeriodStart time.Time  `json:"period_start"`
+	PeriodEnd   time.Time  `json:"period_end"`
+	Spend       float64    `json:"spend"`
+	Limit       float64    `json:"limit"`
+	Currency    string     `json:"currency" gorm:"size:3"`
+	SuspendedAt time.Time  `json:"suspended_at"`
+	ResumedAt   *time.Time `json:"resumed_at"`
+	Reason      string     `json:"reason,omitempty"` // причина снятия
+}
+
+// Режимы налогообложения
+const (
+	TaxModeStandard      = "standard"       // налог по ставке Rate
+	TaxModeExempt        = "exempt"         // освобождение от налога
+	TaxModeReverseCharge = "reverse_charge" // налог уплачивает покупатель
+)
+
+// Налоговый профиль арендатора (например, НДС РФ 20%)
+type TaxProfile struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Code      string    `json:"code" gorm:"uniqueIndex;not null"` // RU_VAT_20, EXEMPT, ...
+	Name      string    `json:"name" gorm:"not null"`
+	Mode      string    `json:"mode" gorm:"not null;default:'standard'"`
+	Rate      float64   `json:"rate"` // доля: 0.20 = 20%
+	Active    bool      `json:"active" gorm:"default:true"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Статусы счёта. Допустимые переходы: draft -> final -> paid,
+// draft|final -> void. Финальный счёт больше не пересчитывается.
+const (
+	BillStatusDraft = "draft"
+	BillStatusFinal = "final"
+	BillStatusPaid  = "paid"
+	BillStatusVoid  = "void"
+)
+
+type Bill struct {
+	ID            uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	TenantID      uuid.UUID `json:"tenant_id" gorm:"not null;index"`
+	InvoiceNumber *string   `json:"invoice_number" gorm:"uniqueIndex"` // присваивается при финализации
+	PeriodStart   time.Time `json:"period_start"`
+	PeriodEnd     time.Time `json:"period_end"`
+	Subtotal      float64   `json:"subtotal"` // сумма без налога
+	TaxAmount     float64   `json:"tax_amount"`
+	TotalAmount   float64   `json:"total_amount"` // сумма к оплате с налогом
+	Currency      string    `json:"currency"`
+	Status        string    `json:"status" gorm:"default:'draft';index"` // draft, final, paid, void
+
+	TaxProfileID *uuid.UUID `json:"tax_profile_id" gorm:"type:uuid"`
+	TaxMode      string     `json:"tax_mode,omitempty"`
+	TaxRate      float64    `json:"tax_rate"`
+	TaxInclusive bool       `json:"tax_inclusive"`
+
+	ContractID *uuid.UUID `json:"contract_id" gorm:"type:uuid"`
+
+	CreditsApplied   float64 `json:"credits_applied"`
+	BalanceRemaining float64 `json:"balance_remaining"` // остаток кредитов после счёта
+
+	// Пересчёт из валюты плана в валюту арендатора
+	SourceCurrency   string     `json:"source_currency,omitempty"`
+	ExchangeRate     float64    `json:"exchange_rate,omitempty"`
+	ExchangeRateDate *time.Time `json:"exchange_rate_date,omitempty"`
+	LineItems     JSONB     `json:"line_items" gorm:"type:jsonb"`
+
+	// Замороженные при финализации данные: тариф и итоги по агрегатам
+	PricingPlanID *uuid.UUID `json:"pricing_plan_id" gorm:"type:uuid"`
+	PlanSnapshot  JSONB      `json:"plan_snapshot,omitempty" gorm:"type:jsonb"`
+	UsageSnapshot JSONB      `json:"usage_snapshot,omitempty" gorm:"type:jsonb"`
+
+	FinalizedAt      *time.Time `json:"finalized_at"`
+	PaidAt           *time.Time `json:"paid_at"`
+	PaymentReference string     `json:"payment_reference,omitempty"`
+	VoidedAt         *time.Time `json:"voided_at"`
+	VoidReason       string     `json:"void_reason,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
 	Tenant Tenant `json:"tenant" gorm:"foreignKey:TenantID"`
 }
 
+// Счётчик номеров счетов в разрезе арендатора
+type InvoiceSequence struct {
+	TenantID   uuid.UUID `json:"tenant_id" gorm:"type:uuid;primary_key"`
+	LastNumber int64     `json:"last_number" gorm:"not null;default:0"`
+}
+
+// Запуск ежемесячного биллинга (cmd/billing-run)
+type BillingRun struct {
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Month      string     `json:"month"` // "2026-09"; пусто = предыдущий месяц в таймзоне арендатора
+	Status     string     `json:"status"` // running, completed, partial, failed
+	Succeeded  int        `json:"succeeded"`
+	Skipped    int        `json:"skipped"`
+	Failed     int        `json:"failed"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+
+	Items []BillingRunItem `json:"items,omitempty" gorm:"foreignKey:RunID"`
+}
+
+// Результат запуска по одному арендатору
+type BillingRunItem struct {
+	ID          uint       `json:"id" gorm:"primary_key"`
+	RunID       uuid.UUID  `json:"run_id" gorm:"type:uuid;index"`
+	TenantID    uuid.UUID  `json:"tenant_id" gorm:"type:uuid;index"`
+	Month       string     `json:"month"`
+	PeriodStart time.Time  `json:"period_start"`
+	PeriodEnd   time.Time  `json:"period_end"`
+	Outcome     string     `json:"outcome"` // finalized, skipped, failed
+	BillID      *uuid.UUID `json:"bill_id" gorm:"type:uuid"`
+	Message     string     `json:"message"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Коды строк счёта (SKU). Описание строки берётся из каталога
+// сообщений по коду и локали.
+const (
+	LineItemInvocations    = "invocations"
+	LineItemComputeGBHours = "compute_gb_hours"
+	LineItemEgressGB       = "egress_gb"
+	LineItemVCPUHours      = "vcpu_hours"
+	LineItemColdStarts     = "cold_starts"
+	LineItemColdStartInit  = "cold_start_init_gb_seconds"
+	LineItemCredits        = "credits"
+	LineItemDiscount       = "discount"              // скидка по контракту, AppliesTo - код строки
+	LineItemCommitTrueUp   = "commit_true_up"        // доплата до минимального платежа
+	LineItemLateUsage      = "late_usage_adjustment" // доначисление за опоздавшее использование прошлого счёта
+)
+
 type BillingLineItem struct {
+	Code           string  `json:"code"`
+	AppliesTo      string  `json:"applies_to,omitempty"` // код строки, к которой относится скидка
+	Unit           string  `json:"unit"`                 // единица количества: invocation, gb_hour, gb, cold_start
 	Description    string  `json:"description"`
 	Quantity       float64 `json:"quantity"`
 	UnitPrice      float64 `json:"unit_price"`
@@ -163,6 +569,18 @@ type BillingLineItem struct {
 	BillableAmount float64 `json:"billable_amount"`
 	TotalCost      float64 `json:"total_cost"`
 	Currency       string  `json:"currency"`
+
+	// Заполняются, если тариф менялся внутри периода счёта
+	PeriodStart   *time.Time `json:"period_start,omitempty"`
+	PeriodEnd     *time.Time `json:"period_end,omitempty"`
+	PricingPlanID *uuid.UUID `json:"pricing_plan_id,omitempty"`
+	PlanVersion   int        `json:"plan_version,omitempty"`
+
+	// Заполняются, если строка пересчитана из валюты плана; у каждого
+	// отрезка свой курс
+	SourceCurrency   string     `json:"source_currency,omitempty"`
+	ExchangeRate     float64    `json:"exchange_rate,omitempty"`
+	ExchangeRateDate *time.Time `json:"exchange_rate_date,omitempty"`
 }
 
 type BillingResult struct {
@@ -170,11 +588,41 @@ type BillingResult struct {
 	PeriodStart     time.Time           `json:"period_start"`
 	PeriodEnd       time.Time           `json:"period_end"`
 	LineItems       []BillingLineItem   `json:"line_items"`
-	TotalCost       float64             `json:"total_cost"`
-	Currency        string              `json:"currency"`
+	Subtotal        float64             `json:"subtotal"`   // без налога
+	ContractID      *uuid.UUID          `json:"contract_id,omitempty"`
+	AdjustmentIDs   []uuid.UUID         `json:"adjustment_ids,omitempty"` // корректировки в строках late_usage_adjustment
+	Credits         CreditSummary       `json:"credits"`
+	Tax             TaxSummary          `json:"tax"`
+	TotalCost       float64             `json:"total_cost"` // с налогом
+	Currency        string              `json:"currency"`   // валюта арендатора
+
+	SourceCurrency   string     `json:"source_currency,omitempty"` // валюта плана, если отличается
+	ExchangeRate     float64    `json:"exchange_rate,omitempty"`
+	ExchangeRateDate *time.Time `json:"exchange_rate_date,omitempty"`
+
 	FreeTierSummary FreeTierSummary     `json:"free_tier_summary"`
 }
 
+type CreditSummary struct {
+	Applied          float64            `json:"applied"`
+	BalanceRemaining float64            `json:"balance_remaining"`
+	Allocations      []CreditAllocation `json:"allocations,omitempty"`
+}
+
+type CreditAllocation struct {
+	GrantID uuid.UUID `json:"grant_id"`
+	Amount  float64   `json:"amount"`
+}
+
+type TaxSummary struct {
+	ProfileID *uuid.UUID `json:"profile_id"`
+	Code      string     `json:"code,omitempty"`
+	Mode      string     `json:"mode,omitempty"`
+	Rate      float64    `json:"rate"`
+	Inclusive bool       `json:"inclusive"` // налог выделен из цен плана
+	Amount    float64    `json:"amount"`
+}
+
 type FreeTierSummary struct {
 	InvocationsUsed  int64   `json:"invocations_used"`
 	InvocationsLimit int64   `json:"invocations_limit"`
@@ -182,4 +630,16 @@ type FreeTierSummary struct {
 	GBHoursLimit     float64 `json:"gb_hours_limit"`
 	EgressGBUsed     float64 `json:"egress_gb_used"`
 	EgressGBLimit    float64 `json:"egress_gb_limit"`
+	ColdStartsUsed   int64   `json:"cold_starts_used"`
+	ColdStartsLimit  int64   `json:"cold_starts_limit"`
+	VCPUHoursUsed    float64 `json:"vcpu_hours_used"`
+	VCPUHoursLimit   float64 `json:"vcpu_hours_limit"`
+}
+
+func (BalanceEntry) BeforeUpdate(*gorm.DB) error {
+	return ErrLedgerAppendOnly
+}
+
+func (BalanceEntry) BeforeDelete(*gorm.DB) error {
+	return ErrLedgerAppendOnly
 }
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lypolix/FaaS-billing/internal/models"
+	"gorm.io/gorm"
+)
+
+// ReconcileLateUsage пересчитывает финализированный счёт, в период которого
+// попало окно с опоздавшими событиями, и приводит ожидающую корректировку
+// к разнице между пересчётом и счётом (за вычетом уже применённых
+// корректировок). Возвращает nil, если окно не относится к финальному счёту.
+func (s *BillingService) ReconcileLateUsage(tenantID uuid.UUID, at time.Time) (*models.Bill, *models.UsageAdjustment, error) {
+	var bill models.Bill
+	err := s.db.Where("tenant_id = ? AND status IN ? AND period_start <= ? AND period_end > ?",
+		tenantID, []string{models.BillStatusFinal, models.BillStatusPaid}, at, at).
+		First(&bill).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	calc, err := s.calculate(tenantID.String(), bill.PeriodStart, bill.PeriodEnd)
+	if err != nil {
+		return nil, nil, err
+	}
+	items, err := billItems(bill)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bill %s: %w", bill.ID, err)
+	}
+	// сравниваются строки периода: без кредитов и корректировок прошлых счетов
+	diff := adjustableNet(calc.Result.LineItems) - adjustableNet(items)
+	if normalizeCurrency(calc.Result.Currency) != normalizeCurrency(bill.Currency) {
+		return &bill, nil, fmt.Errorf("bill %s currency %s differs from recalculation %s", bill.ID, bill.Currency, calc.Result.Currency)
+	}
+
+	var adj *models.UsageAdjustment
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var applied float64
+		if err := tx.Model(&models.UsageAdjustment{}).
+			Where("bill_id = ? AND status = ?", bill.ID, models.AdjustmentApplied).
+			Select("COALESCE(SUM(amount), 0)").Scan(&applied).Error; err != nil {
+			return err
+		}
+		amount := roundMoney(diff - applied)
+
+		var pending models.UsageAdjustment
+		err := tx.Where("bill_id = ? AND status = ?", bill.ID, models.AdjustmentPending).First(&pending).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		found := err == nil
+
+		if math.Abs(amount) < 0.01 {
+			if found {
+				pending.Status = models.AdjustmentVoid
+				pending.Amount = 0
+				adj = &pending
+				return tx.Save(&pending).Error
+			}
+			return nil
+		}
+		if !found {
+			pending = models.UsageAdjustment{
+				ID:          uuid.New(),
+				TenantID:    tenantID,
+				BillID:      bill.ID,
+				PeriodStart: bill.PeriodStart,
+				PeriodEnd:   bill.PeriodEnd,
+				Currency:    normalizeCurrency(bill.Currency),
+				Status:      models.AdjustmentPending,
+			}
+		}
+		pending.Amount = amount
+		adj = &pending
+		return tx.Save(&pending).Error
+	})
+	return &bill, adj, err
+}
+
+// reconcileLateWindow сверяет окно с опоздавшими событиями с финализированным
+// счётом его периода и запоминает в окне счёт и корректировку
+func reconcileLateWindow(db *gorm.DB, billing *BillingService, lw *models.LateWindow) error {
+	bill, adj, err := billing.ReconcileLateUsage(lw.TenantID, lw.WindowStart)
+	if err != nil || bill == nil {
+		return err
+	}
+	lw.BillID = &bill.ID
+	if adj != nil {
+		lw.AdjustmentID = &adj.ID
+		log.Printf("late events: tenant=%s window=%s affect finalized bill %s, adjustment %.2f %s (%s)",
+			lw.TenantID, lw.WindowStart.Format(time.RFC3339), bill.ID, adj.Amount, adj.Currency, adj.Status)
+	}
+	return db.Save(lw).Error
+}
+
+func adjustableNet(items []models.BillingLineItem) float64 {
+	var net float64
+	for _, it := range items {
+		if it.Code == models.LineItemCredits || it.Code == models.LineItemLateUsage {
+			continue
+		}
+		net += it.TotalCost
+	}
+	return roundMoney(net)
+}
+
+// applyAdjustments добавляет в расчёт ожидающие корректировки счетов,
+// закончившихся до начала периода. Они идут после скидок и минимального
+// платежа (уже учтены в сумме), но до кредитов и налога.
+func applyAdjustments(db *gorm.DB, result *models.BillingResult, periodStart time.Time) error {
+	var adjs []models.UsageAdjustment
+	if err := db.Where("tenant_id = ? AND status = ? AND period_end <= ? AND currency = ?",
+		result.TenantID, models.AdjustmentPending, periodStart, normalizeCurrency(result.Currency)).
+		Order("period_start").Find(&adjs).Error; err != nil {
+		return err
+	}
+	addAdjustments(result, adjs)
+	return nil
+}
+
+// addAdjustments добавляет корректировки строками late_usage_adjustment
+func addAdjustments(result *models.BillingResult, adjs []models.UsageAdjustment) {
+	for _, a := range adjs {
+		a := a
+		result.LineItems = append(result.LineItems, models.BillingLineItem{
+			Code:           models.LineItemLateUsage,
+			Unit:           result.Currency,
+			Description:    lineItemDescription(models.LineItemLateUsage),
+			Quantity:       a.Amount,
+			UnitPrice:      1,
+			BillableAmount: a.Amount,
+			TotalCost:      a.Amount,
+			Currency:       result.Currency,
+			PeriodStart:    &a.PeriodStart,
+			PeriodEnd:      &a.PeriodEnd,
+		})
+		result.AdjustmentIDs = append(result.AdjustmentIDs, a.ID)
+	}
+}
+
+// markAdjustmentsApplied закрывает корректировки, вошедшие в финализированный счёт
+func markAdjustmentsApplied(tx *gorm.DB, bill *models.Bill, ids []uuid.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return tx.Model(&models.UsageAdjustment{}).
+		Where("id IN ? AND status = ?", ids, models.AdjustmentPending).
+		Updates(map[string]interface{}{"status": models.AdjustmentApplied, "applied_bill_id": bill.ID}).Error
+}
+
+// reopenAdjustments возвращает корректировки аннулированного счёта в ожидание
+func reopenAdjustments(tx *gorm.DB, bill *models.Bill) error {
+	return tx.Model(&models.UsageAdjustment{}).
+		Where("applied_bill_id = ? AND status = ?", bill.ID, models.AdjustmentApplied).
+		Updates(map[string]interface{}{"status": models.AdjustmentPending, "applied_bill_id": nil}).Error
+}
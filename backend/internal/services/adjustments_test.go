@@ -0,0 +1,50 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/lypolix/FaaS-billing/internal/models"
+)
+
+func TestAddAdjustments(t *testing.T) {
+	jan := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	adjustment := func(start time.Time, amount float64) models.UsageAdjustment {
+		return models.UsageAdjustment{ID: uuid.New(), PeriodStart: start, PeriodEnd: start.AddDate(0, 1, 0), Amount: amount}
+	}
+	tests := []struct {
+		name string
+		adjs []models.UsageAdjustment
+	}{
+		{name: "none"},
+		{name: "one per adjusted bill", adjs: []models.UsageAdjustment{adjustment(jan, 12.5), adjustment(jan.AddDate(0, 1, 0), -3)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &models.BillingResult{
+				LineItems: []models.BillingLineItem{{Code: models.LineItemInvocations, TotalCost: 10}},
+				Currency:  "USD",
+			}
+			addAdjustments(result, tt.adjs)
+
+			added := result.LineItems[1:]
+			if len(added) != len(tt.adjs) || len(result.AdjustmentIDs) != len(tt.adjs) {
+				t.Fatalf("lines %d, ids %d, want %d", len(added), len(result.AdjustmentIDs), len(tt.adjs))
+			}
+			for i, a := range tt.adjs {
+				it := added[i]
+				if it.Code != models.LineItemLateUsage || it.TotalCost != a.Amount || it.Currency != "USD" {
+					t.Errorf("line %d = %+v, want %s of %v USD", i, it, models.LineItemLateUsage, a.Amount)
+				}
+				if it.PeriodStart == nil || !it.PeriodStart.Equal(a.PeriodStart) || !it.PeriodEnd.Equal(a.PeriodEnd) {
+					t.Errorf("line %d period = %v..%v, want %v..%v", i, it.PeriodStart, it.PeriodEnd, a.PeriodStart, a.PeriodEnd)
+				}
+				if result.AdjustmentIDs[i] != a.ID {
+					t.Errorf("adjustment id %d = %s, want %s", i, result.AdjustmentIDs[i], a.ID)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,370 @@
+// Package aggregation - единственный движок агрегации usage_raws в
+// usage_aggregates: им пользуются POST /metrics/aggregate (MetricsService),
+// расчёт счетов по требованию (BillingRunService) и cmd/aggregator.
+//
+// Окно [start, end) считается одним SQL-запросом на арендатора по правилам
+// его плана на начало окна. По ключу (tenant, service, revision):
+//
+//	invocations           = Σ invocations
+//	total_duration_ms     = Σ duration_ms
+//	avg_duration_ms       = avg(duration_ms)
+//	p50/p95_duration_ms   = percentile_cont(0.5 / 0.95) по duration_ms
+//	billable_duration_ms  = Σ ceil(max(duration_ms, min) / step) × step
+//	avg/max_memory_mb     = avg/max(memory_mb), memory_samples - число замеров
+//	total_memory_mb_hours = avg(memory_mb) × длина окна в часах (measured)
+//	                      = выделенная память × billable_duration_ms / 3 600 000 (allocated)
+//	cold_start_gb_seconds = Σ cold_start_ms / 1000 × память / 1024
+//	cold_starts, cold_start_init_ms, errors, egress_bytes, total_cpu_ms - суммы
+//
+// Выделенная память - memory_mb из scaling_config ревизии, иначе
+// memory_limit_mb сервиса; без неё allocated считается как measured, а для
+// холодных стартов берётся средняя память. Учтённые события удаляются, а
+// повторная агрегация окна добавляется к сохранённому агрегату
+// (MergeAggregate), поэтому результат не зависит от числа проходов.
+package aggregation
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/lypolix/FaaS-billing/internal/models"
+)
+
+type Engine struct {
+	db    *gorm.DB
+	rules RulesFunc
+
+	// OnLate вызывается для каждого арендатора после догрузки опоздавших
+	// событий в уже посчитанное окно (сверка с финализированными счетами)
+	OnLate func(lw *models.LateWindow) error
+}
+
+func NewEngine(db *gorm.DB, rules RulesFunc) *Engine {
+	if rules == nil {
+		rules = func(uuid.UUID, time.Time) UsageRules { return DefaultUsageRules }
+	}
+	return &Engine{db: db, rules: rules}
+}
+
+// Result - итог агрегации окна
+type Result struct {
+	WindowSize  string    `json:"window_size"`
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+	Events      int64     `json:"events"`   // учтённых сырых записей
+	Rows        int       `json:"rows"`     // агрегатов, к которым они добавлены
+	Late        bool      `json:"late"`     // окно было посчитано раньше
+	Complete    bool      `json:"complete"` // окно отмечено завершённым
+}
+
+// AggregateRange агрегирует окна размера size, пересекающие [start, end);
+// tenantID ограничивает агрегацию событиями одного арендатора
+func (e *Engine) AggregateRange(start, end time.Time, size string, tenantID *uuid.UUID) ([]Result, error) {
+	d, err := ParseWindowSize(size)
+	if err != nil {
+		return nil, err
+	}
+	var out []Result
+	for cur := start.UTC().Truncate(d); cur.Before(end); cur = cur.Add(d) {
+		res, err := e.AggregateWindow(cur, size, tenantID)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, res)
+	}
+	return out, nil
+}
+
+type tenantEvents struct {
+	TenantID uuid.UUID
+	Events   int64
+}
+
+// AggregateWindow агрегирует окно, начинающееся в start. Закончившееся окно,
+// посчитанное по всем арендаторам, отмечается завершённым (пустое тоже,
+// иначе не свернётся час); события в уже отмеченном окне - опоздавшие и
+// записываются в late_windows.
+//
+// Окно считается в одной транзакции под advisory lock на (size, start), так
+// что демон, POST /metrics/aggregate и расчёт счетов не учитывают события
+// дважды. События забираются из usage_raws через DELETE ... RETURNING:
+// удаляются ровно те записи, что вошли в агрегат, а пришедшие во время
+// прохода ждут следующего.
+func (e *Engine) AggregateWindow(start time.Time, size string, tenantID *uuid.UUID) (Result, error) {
+	d, err := ParseWindowSize(size)
+	if err != nil {
+		return Result{}, err
+	}
+	start = start.UTC().Truncate(d)
+	end := start.Add(d)
+	res := Result{WindowSize: size, WindowStart: start, WindowEnd: end}
+
+	var late []*models.LateWindow
+	err = e.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))",
+			"usage_window:"+size+":"+start.Format(time.RFC3339)).Error; err != nil {
+			return err
+		}
+
+		var marked int64
+		if err := tx.Model(&models.AggregationWindow{}).
+			Where("window_size = ? AND window_start = ? AND source = ''", size, start).
+			Count(&marked).Error; err != nil {
+			return err
+		}
+		res.Late = marked > 0
+		res.Complete = res.Late
+
+		// window_raws - события, забранные этим проходом
+		if err := tx.Exec("CREATE TEMP TABLE window_raws (LIKE usage_raws) ON COMMIT DROP").Error; err != nil {
+			return err
+		}
+		claim := "WITH d AS (DELETE FROM usage_raws WHERE timestamp >= ? AND timestamp < ?"
+		args := []any{start, end}
+		if tenantID != nil {
+			claim += " AND tenant_id = ?"
+			args = append(args, *tenantID)
+		}
+		claim += " RETURNING *) INSERT INTO window_raws SELECT * FROM d"
+		claimed := tx.Exec(claim, args...)
+		if claimed.Error != nil {
+			return claimed.Error
+		}
+		if claimed.RowsAffected == 0 && (res.Late || tenantID != nil) {
+			return nil
+		}
+
+		var counts []tenantEvents
+		if err := tx.Table("window_raws").
+			Select("tenant_id, COUNT(*) AS events").Group("tenant_id").
+			Scan(&counts).Error; err != nil {
+			return err
+		}
+		for _, c := range counts {
+			res.Events += c.Events
+			rows, err := tenantRows(tx, start, end, c.TenantID, e.rules(c.TenantID, start))
+			if err != nil {
+				return fmt.Errorf("tenant %s: %w", c.TenantID, err)
+			}
+			res.Rows += len(rows)
+			for _, r := range rows {
+				if err := MergeAggregate(tx, r.aggregate(start, end, size), r.AllocatedMemory); err != nil {
+					return err
+				}
+			}
+		}
+
+		if res.Late {
+			now := time.Now()
+			for _, c := range counts {
+				lw, err := RecordLateWindow(tx, size, start, end, c.TenantID, c.Events, now)
+				if err != nil {
+					return err
+				}
+				late = append(late, lw)
+			}
+			return nil
+		}
+		// окно одного арендатора или ещё не закончившееся окно не завершено
+		if tenantID != nil || end.After(time.Now()) {
+			return nil
+		}
+		var n int64
+		if err := tx.Model(&models.UsageAggregate{}).
+			Where("window_size = ? AND window_start = ?", size, start).
+			Count(&n).Error; err != nil {
+			return err
+		}
+		res.Complete = true
+		return MarkWindowComplete(tx, size, start, end, "", int(n))
+	})
+	if err != nil {
+		return res, err
+	}
+
+	if e.OnLate != nil {
+		for _, lw := range late {
+			if err := e.OnLate(lw); err != nil {
+				return res, fmt.Errorf("late window tenant %s: %w", lw.TenantID, err)
+			}
+		}
+	}
+	return res, nil
+}
+
+// ReaggregateLate догружает события с меткой времени в [since, before) в уже
+// посчитанные окна размера size. Нулевой since - без нижней границы (полный
+// просмотр usage_raws). Возвращает число догруженных окон и окон, которые
+// ещё не считались (их события не трогаются).
+func (e *Engine) ReaggregateLate(since, before time.Time, size string) (late, missed int, err error) {
+	d, err := ParseWindowSize(size)
+	if err != nil {
+		return 0, 0, err
+	}
+	var windows []struct {
+		Start  time.Time
+		Marked bool
+	}
+	if err := e.db.Raw(`
+		SELECT b.start, EXISTS (
+			SELECT 1 FROM aggregation_windows m
+			WHERE m.window_size = ? AND m.source = '' AND m.window_start = b.start
+		) AS marked
+		FROM (
+			SELECT DISTINCT to_timestamp(floor(extract(epoch FROM timestamp) / ?) * ?) AS start
+			FROM usage_raws WHERE timestamp >= ? AND timestamp < ?
+		) b
+		ORDER BY b.start`,
+		size, d.Seconds(), d.Seconds(), since, before).Scan(&windows).Error; err != nil {
+		return 0, 0, err
+	}
+
+	for _, w := range windows {
+		if !w.Marked {
+			missed++
+			continue
+		}
+		if _, err := e.AggregateWindow(w.Start, size, nil); err != nil {
+			return late, missed, fmt.Errorf("window %s: %w", w.Start.UTC().Format(time.RFC3339), err)
+		}
+		late++
+	}
+	return late, missed, nil
+}
+
+// row - агрегат ключа окна, как его возвращает tenantRows
+type row struct {
+	TenantID   uuid.UUID
+	ServiceID  uuid.UUID
+	RevisionID *uuid.UUID
+
+	Invocations        int64
+	TotalDurationMS    int64
+	BillableDurationMS int64
+	AvgDurationMS      float64
+	P50DurationMS      float64
+	P95DurationMS      float64
+
+	MaxMemoryMB        float64
+	AvgMemoryMB        float64
+	MemorySamples      int64
+	TotalMemoryMBHours float64
+	AllocatedMemory    bool
+
+	ColdStarts         int64
+	ColdStartInitMS    int64
+	ColdStartGBSeconds float64
+	Errors             int64
+	EgressBytes        int64
+	TotalCPUMS         int64
+}
+
+func (r row) aggregate(start, end time.Time, size string) models.UsageAggregate {
+	return models.UsageAggregate{
+		WindowStart:        start,
+		WindowEnd:          end,
+		WindowSize:         size,
+		TenantID:           r.TenantID,
+		ServiceID:          r.ServiceID,
+		RevisionID:         r.RevisionID,
+		Invocations:        r.Invocations,
+		TotalDurationMS:    r.TotalDurationMS,
+		BillableDurationMS: r.BillableDurationMS,
+		AvgDurationMS:      r.AvgDurationMS,
+		P50DurationMS:      r.P50DurationMS,
+		P95DurationMS:      r.P95DurationMS,
+		MaxMemoryMB:        r.MaxMemoryMB,
+		AvgMemoryMB:        r.AvgMemoryMB,
+		MemorySamples:      r.MemorySamples,
+		TotalMemoryMBHours: r.TotalMemoryMBHours,
+		ColdStarts:         int(r.ColdStarts),
+		ColdStartInitMS:    r.ColdStartInitMS,
+		ColdStartGBSeconds: r.ColdStartGBSeconds,
+		Errors:             int(r.Errors),
+		EgressBytes:        r.EgressBytes,
+		TotalCPUMS:         r.TotalCPUMS,
+	}
+}
+
+// tenantRows считает агрегаты арендатора по событиям window_raws, забранным
+// AggregateWindow в транзакции tx, по формулам из описания пакета
+func tenantRows(tx *gorm.DB, start, end time.Time, tenantID uuid.UUID, rules UsageRules) ([]row, error) {
+	step := rules.RoundingMS
+	if step < 1 {
+		step = 1
+	}
+	allocated := rules.MemoryMode == models.MemoryBillingAllocated
+
+	q := `
+		WITH raw AS (
+			SELECT u.*,
+				COALESCE(
+					NULLIF(NULLIF(r.scaling_config->>'memory_mb', '')::float8, 0),
+					NULLIF(s.memory_limit_mb, 0)::float8
+				) AS alloc_mb
+			FROM window_raws u
+			LEFT JOIN services s ON s.id = u.service_id
+			LEFT JOIN revisions r ON r.id = u.revision_id
+			WHERE u.timestamp >= $1 AND u.timestamp < $2 AND u.tenant_id = $4
+		),
+		g AS (
+			SELECT
+				tenant_id, service_id, revision_id,
+				MAX(alloc_mb) AS alloc_mb,
+
+				COALESCE(SUM(value) FILTER (WHERE metric_name = 'invocations'), 0) AS invocations,
+				COALESCE(SUM(value) FILTER (WHERE metric_name = 'duration_ms'), 0) AS total_duration_ms,
+				COALESCE(AVG(value) FILTER (WHERE metric_name = 'duration_ms'), 0) AS avg_duration_ms,
+				COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY value) FILTER (WHERE metric_name = 'duration_ms'), 0) AS p50_duration_ms,
+				COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY value) FILTER (WHERE metric_name = 'duration_ms'), 0) AS p95_duration_ms,
+				-- каждый вызов: не меньше минимума, округление вверх до шага
+				COALESCE(SUM(CEIL(GREATEST(value, $5) / $6) * $6) FILTER (WHERE metric_name = 'duration_ms'), 0) AS billable_duration_ms,
+
+				COALESCE(MAX(value) FILTER (WHERE metric_name = 'memory_mb'), 0) AS max_memory_mb,
+				COALESCE(AVG(value) FILTER (WHERE metric_name = 'memory_mb'), 0) AS avg_memory_mb,
+				COUNT(*) FILTER (WHERE metric_name = 'memory_mb') AS memory_samples,
+
+				COALESCE(SUM(value) FILTER (WHERE metric_name = 'cold_starts'), 0) AS cold_starts,
+				COALESCE(SUM(value) FILTER (WHERE metric_name = 'cold_start_ms'), 0) AS cold_start_init_ms,
+				COALESCE(SUM(value) FILTER (WHERE metric_name = 'errors'), 0) AS errors,
+				COALESCE(SUM(value) FILTER (WHERE metric_name = 'egress_bytes'), 0) AS egress_bytes,
+				COALESCE(SUM(value) FILTER (WHERE metric_name = 'cpu_ms'), 0) AS total_cpu_ms
+			FROM raw
+			GROUP BY tenant_id, service_id, revision_id
+		)
+		SELECT
+			tenant_id, service_id, revision_id,
+
+			invocations::bigint AS invocations,
+			total_duration_ms::bigint AS total_duration_ms,
+			ROUND(billable_duration_ms)::bigint AS billable_duration_ms,
+			avg_duration_ms::float8 AS avg_duration_ms,
+			p50_duration_ms::float8 AS p50_duration_ms,
+			p95_duration_ms::float8 AS p95_duration_ms,
+
+			max_memory_mb::float8 AS max_memory_mb,
+			avg_memory_mb::float8 AS avg_memory_mb,
+			memory_samples::bigint AS memory_samples,
+			(CASE WHEN $7 AND alloc_mb IS NOT NULL
+				THEN alloc_mb * billable_duration_ms / 3600000.0
+				ELSE avg_memory_mb * $3 / 3600.0
+			END)::float8 AS total_memory_mb_hours,
+			($7 AND alloc_mb IS NOT NULL) AS allocated_memory,
+
+			cold_starts::bigint AS cold_starts,
+			cold_start_init_ms::bigint AS cold_start_init_ms,
+			(cold_start_init_ms / 1000.0 * COALESCE(alloc_mb, avg_memory_mb) / 1024.0)::float8 AS cold_start_gb_seconds,
+			errors::bigint AS errors,
+			egress_bytes::bigint AS egress_bytes,
+			total_cpu_ms::bigint AS total_cpu_ms
+		FROM g
+	`
+
+	var out []row
+	err := tx.Raw(q, start, end, end.Sub(start).Seconds(), tenantID,
+		rules.MinDurationMS, step, allocated).Scan(&out).Error
+	return out, err
+}
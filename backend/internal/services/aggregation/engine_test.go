@@ -0,0 +1,303 @@
+package aggregation_test
+
+import (
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+
+	"github.com/lypolix/FaaS-billing/internal/database"
+	"github.com/lypolix/FaaS-billing/internal/models"
+	"github.com/lypolix/FaaS-billing/internal/services"
+)
+
+// testSchema пересоздаётся при каждом запуске: таблицы базы из
+// TEST_DATABASE_URL вне этой схемы тесты не трогают
+const testSchema = "aggregation_engine_test"
+
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	cfg := &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}
+	admin, err := gorm.Open(postgres.Open(dsn), cfg)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if err := admin.Exec(`DROP SCHEMA IF EXISTS ` + testSchema + ` CASCADE; CREATE SCHEMA ` + testSchema).Error; err != nil {
+		t.Fatalf("schema: %v", err)
+	}
+	if sqlDB, err := admin.DB(); err == nil {
+		sqlDB.Close()
+	}
+
+	// все соединения пула работают в testSchema
+	if strings.Contains(dsn, "://") {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		dsn += sep + "search_path=" + testSchema
+	} else {
+		dsn += " search_path=" + testSchema
+	}
+	db, err := gorm.Open(postgres.Open(dsn), cfg)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	var schema string
+	if err := db.Raw(`SELECT current_schema()`).Scan(&schema).Error; err != nil || schema != testSchema {
+		t.Fatalf("search_path is not %s (current schema %q): %v", testSchema, schema, err)
+	}
+	database.DB = db
+	database.Migrate()
+	return db
+}
+
+var windowStart = time.Date(2026, 1, 7, 12, 0, 0, 0, time.UTC)
+
+type fixture struct {
+	measuredTenant, allocatedTenant uuid.UUID
+	measuredService, allocService   uuid.UUID
+	allocRevision                   uuid.UUID
+}
+
+// seed создаёт арендатора с учётом памяти по замерам и арендатора с
+// выделенной памятью, минимальной длительностью 100 мс и шагом 100 мс
+func seed(t *testing.T, db *gorm.DB) fixture {
+	t.Helper()
+	if err := db.Exec(`TRUNCATE usage_raws, usage_aggregates, aggregation_windows, late_windows,
+		usage_adjustments, revisions, services, tenant_plan_assignments, tenants, pricing_plans CASCADE`).Error; err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	since := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	measuredPlan := models.PricingPlan{Name: "measured", MemoryBillingMode: models.MemoryBillingMeasured, EffectiveFrom: since, Active: true}
+	allocPlan := models.PricingPlan{Name: "allocated", MemoryBillingMode: models.MemoryBillingAllocated,
+		MinBillableDurationMS: 100, DurationRoundingMS: 100, EffectiveFrom: since, Active: true}
+	for _, p := range []*models.PricingPlan{&measuredPlan, &allocPlan} {
+		if err := db.Omit(clause.Associations).Create(p).Error; err != nil {
+			t.Fatalf("plan: %v", err)
+		}
+	}
+
+	// ключи одинаковы в каждом прогоне, чтобы агрегаты можно было сравнить
+	f := fixture{
+		measuredTenant:  uuid.MustParse("00000000-0000-0000-0000-000000000001"),
+		allocatedTenant: uuid.MustParse("00000000-0000-0000-0000-000000000002"),
+		measuredService: uuid.MustParse("00000000-0000-0000-0000-000000000011"),
+		allocService:    uuid.MustParse("00000000-0000-0000-0000-000000000012"),
+		allocRevision:   uuid.MustParse("00000000-0000-0000-0000-000000000022"),
+	}
+	rows := []any{
+		&models.Tenant{ID: f.measuredTenant, Name: "measured", PricingPlanID: &measuredPlan.ID},
+		&models.Tenant{ID: f.allocatedTenant, Name: "allocated", PricingPlanID: &allocPlan.ID},
+		&models.Service{ID: f.measuredService, TenantID: f.measuredTenant, Name: "fn"},
+		&models.Service{ID: f.allocService, TenantID: f.allocatedTenant, Name: "fn", MemoryLimitMB: 256},
+		&models.Revision{ID: f.allocRevision, ServiceID: f.allocService, Name: "fn-00001",
+			ScalingConfig: models.JSONB{"memory_mb": 512}},
+	}
+	for _, r := range rows {
+		if err := db.Omit(clause.Associations).Create(r).Error; err != nil {
+			t.Fatalf("fixture: %v", err)
+		}
+	}
+	return f
+}
+
+type event struct {
+	tenant, service uuid.UUID
+	revision        *uuid.UUID
+	metric          string
+	value           float64
+}
+
+// events - первая партия событий окна; late - опоздавшие в то же окно
+func (f fixture) events(late bool) []event {
+	rev := &f.allocRevision
+	if late {
+		return []event{
+			{f.measuredTenant, f.measuredService, nil, "invocations", 1},
+			{f.measuredTenant, f.measuredService, nil, "duration_ms", 40},
+			{f.measuredTenant, f.measuredService, nil, "memory_mb", 300},
+			{f.allocatedTenant, f.allocService, rev, "invocations", 1},
+			{f.allocatedTenant, f.allocService, rev, "duration_ms", 250},
+		}
+	}
+	return []event{
+		{f.measuredTenant, f.measuredService, nil, "invocations", 2},
+		{f.measuredTenant, f.measuredService, nil, "duration_ms", 10},
+		{f.measuredTenant, f.measuredService, nil, "duration_ms", 1001},
+		{f.measuredTenant, f.measuredService, nil, "memory_mb", 100},
+		{f.measuredTenant, f.measuredService, nil, "memory_mb", 200},
+		{f.measuredTenant, f.measuredService, nil, "cold_starts", 1},
+		{f.measuredTenant, f.measuredService, nil, "cold_start_ms", 600},
+		{f.measuredTenant, f.measuredService, nil, "egress_bytes", 2048},
+		{f.measuredTenant, f.measuredService, nil, "cpu_ms", 70},
+
+		{f.allocatedTenant, f.allocService, rev, "invocations", 2},
+		{f.allocatedTenant, f.allocService, rev, "duration_ms", 30},
+		{f.allocatedTenant, f.allocService, rev, "duration_ms", 150},
+		{f.allocatedTenant, f.allocService, rev, "memory_mb", 90},
+		{f.allocatedTenant, f.allocService, rev, "cold_starts", 1},
+		{f.allocatedTenant, f.allocService, rev, "cold_start_ms", 800},
+		{f.allocatedTenant, f.allocService, rev, "errors", 1},
+		// без ревизии: выделенная память - лимит сервиса
+		{f.allocatedTenant, f.allocService, nil, "invocations", 1},
+		{f.allocatedTenant, f.allocService, nil, "duration_ms", 101},
+	}
+}
+
+func insertRaws(t *testing.T, db *gorm.DB, events []event) {
+	t.Helper()
+	for i, e := range events {
+		raw := models.UsageRaw{
+			Timestamp:  windowStart.Add(time.Duration(i+1) * time.Second),
+			TenantID:   e.tenant,
+			ServiceID:  e.service,
+			RevisionID: e.revision,
+			MetricName: e.metric,
+			Value:      e.value,
+		}
+		if err := db.Omit(clause.Associations).Create(&raw).Error; err != nil {
+			t.Fatalf("raw: %v", err)
+		}
+	}
+}
+
+func aggregates(t *testing.T, db *gorm.DB) []models.UsageAggregate {
+	t.Helper()
+	var out []models.UsageAggregate
+	if err := db.Where("window_size = ?", "1m").
+		Order("tenant_id, service_id, revision_id NULLS FIRST").
+		Find(&out).Error; err != nil {
+		t.Fatalf("aggregates: %v", err)
+	}
+	for i := range out {
+		out[i].ID = 0
+		out[i].WindowStart = out[i].WindowStart.UTC()
+		out[i].WindowEnd = out[i].WindowEnd.UTC()
+	}
+	return out
+}
+
+// run считает окно с опоздавшими событиями через entrypoint aggregate и
+// возвращает агрегаты окна
+func run(t *testing.T, db *gorm.DB, f fixture, aggregate func(late bool)) []models.UsageAggregate {
+	t.Helper()
+	insertRaws(t, db, f.events(false))
+	aggregate(false)
+	insertRaws(t, db, f.events(true))
+	aggregate(true)
+
+	var left int64
+	db.Model(&models.UsageRaw{}).Count(&left)
+	if left != 0 {
+		t.Fatalf("%d raw events left after aggregation", left)
+	}
+	return aggregates(t, db)
+}
+
+// TestEntrypointsAgree проверяет, что cmd/aggregator и POST /metrics/aggregate
+// (MetricsService) дают одинаковые агрегаты на одних и тех же событиях
+func TestEntrypointsAgree(t *testing.T) {
+	db := testDB(t)
+
+	// cmd/aggregator: окно, затем следующий запуск догружает опоздавшие
+	f := seed(t, db)
+	engine := services.NewAggregationEngine(db)
+	viaAggregator := run(t, db, f, func(late bool) {
+		start := windowStart
+		if late {
+			start = windowStart.Add(time.Minute)
+		}
+		if _, err := engine.AggregateWindow(start, "1m", nil); err != nil {
+			t.Fatalf("aggregator window: %v", err)
+		}
+		if n, _, err := engine.ReaggregateLate(windowStart, start, "1m"); err != nil {
+			t.Fatalf("aggregator late: %v", err)
+		} else if late && n != 1 {
+			t.Fatalf("aggregator re-aggregated %d late windows, want 1", n)
+		}
+	})
+
+	// POST /metrics/aggregate: повторный запрос того же диапазона
+	f = seed(t, db)
+	metrics := services.NewMetricsService(db)
+	viaMetrics := run(t, db, f, func(late bool) {
+		res, err := metrics.AggregateMetrics(windowStart, windowStart.Add(time.Minute), "1m")
+		if err != nil {
+			t.Fatalf("metrics aggregate: %v", err)
+		}
+		if len(res) != 1 || res[0].Late != late {
+			t.Fatalf("metrics aggregate result %+v, want one window with late=%t", res, late)
+		}
+	})
+
+	checkExpected(t, f, viaMetrics)
+	if !reflect.DeepEqual(viaAggregator, viaMetrics) {
+		t.Fatalf("aggregates differ:\naggregator: %+v\nmetrics:    %+v", viaAggregator, viaMetrics)
+	}
+}
+
+// checkExpected сверяет агрегаты с формулами из описания пакета
+func checkExpected(t *testing.T, f fixture, aggs []models.UsageAggregate) {
+	t.Helper()
+	find := func(tenant uuid.UUID, revision bool) models.UsageAggregate {
+		for _, a := range aggs {
+			if a.TenantID == tenant && (a.RevisionID != nil) == revision {
+				return a
+			}
+		}
+		t.Fatalf("no aggregate for tenant %s revision=%t", tenant, revision)
+		return models.UsageAggregate{}
+	}
+	near := func(name string, got, want float64) {
+		if math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+
+	// по замерам: средняя память взвешена числом замеров с учётом опоздавшего
+	m := find(f.measuredTenant, false)
+	if m.Invocations != 3 || m.TotalDurationMS != 1051 || m.BillableDurationMS != 1051 {
+		t.Errorf("measured durations: %+v", m)
+	}
+	if m.MemorySamples != 3 || m.MaxMemoryMB != 300 {
+		t.Errorf("measured memory samples=%d max=%v", m.MemorySamples, m.MaxMemoryMB)
+	}
+	near("measured avg_memory_mb", m.AvgMemoryMB, 200)
+	near("measured total_memory_mb_hours", m.TotalMemoryMBHours, 200.0/60)
+	if m.ColdStarts != 1 || m.ColdStartInitMS != 600 || m.EgressBytes != 2048 || m.TotalCPUMS != 70 {
+		t.Errorf("measured sums: %+v", m)
+	}
+	// холодный старт без выделенной памяти - по средней памяти партии
+	near("measured cold_start_gb_seconds", m.ColdStartGBSeconds, 0.6*150/1024)
+
+	// выделенная память ревизии 512 МБ; 30 -> 100, 150 -> 200, 250 -> 300
+	a := find(f.allocatedTenant, true)
+	if a.Invocations != 3 || a.TotalDurationMS != 430 || a.BillableDurationMS != 600 {
+		t.Errorf("allocated durations: %+v", a)
+	}
+	near("allocated total_memory_mb_hours", a.TotalMemoryMBHours, 512*600/3_600_000.0)
+	near("allocated cold_start_gb_seconds", a.ColdStartGBSeconds, 0.8*512/1024)
+	if a.ColdStarts != 1 || a.ColdStartInitMS != 800 || a.Errors != 1 {
+		t.Errorf("allocated sums: %+v", a)
+	}
+
+	// без ревизии - лимит сервиса 256 МБ; 101 -> 200
+	s := find(f.allocatedTenant, false)
+	if s.BillableDurationMS != 200 {
+		t.Errorf("service-limit billable_duration_ms = %d, want 200", s.BillableDurationMS)
+	}
+	near("service-limit total_memory_mb_hours", s.TotalMemoryMBHours, 256*200/3_600_000.0)
+}
@@ -0,0 +1,184 @@
+package aggregation
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lypolix/FaaS-billing/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MergeAggregate добавляет агрегат части событий окна к уже сохранённому
+// агрегату того же ключа (или создаёт его). Сырые события после агрегации
+// удаляются, поэтому повторная агрегация окна видит только новые события
+// и складывается с прежним результатом, а не заменяет его. Разница
+// переносится в свёртки (1h, 1d), уже покрывающие окно.
+// allocated - память окна учтена по выделенному объёму, а не по замерам.
+func MergeAggregate(tx *gorm.DB, add models.UsageAggregate, allocated bool) error {
+	existing, err := findAggregate(tx, add.WindowSize, add.WindowStart, add.TenantID, add.ServiceID, add.RevisionID)
+	if err != nil {
+		return err
+	}
+	var before models.UsageAggregate
+	if existing == nil {
+		existing = &add
+		if err := tx.Create(existing).Error; err != nil {
+			return err
+		}
+	} else {
+		before = *existing
+		mergeAggregate(existing, add, allocated)
+		if err := tx.Omit(clause.Associations).Save(existing).Error; err != nil {
+			return err
+		}
+	}
+
+	// свёртки, в которые окно уже вошло
+	var rollups []models.AggregationWindow
+	if err := tx.Where("source <> '' AND window_start <= ? AND window_end >= ? AND window_size <> ?",
+		add.WindowStart, add.WindowEnd, add.WindowSize).Find(&rollups).Error; err != nil {
+		return err
+	}
+	for _, w := range rollups {
+		dst, err := findAggregate(tx, w.WindowSize, w.WindowStart, add.TenantID, add.ServiceID, add.RevisionID)
+		if err != nil {
+			return err
+		}
+		if dst == nil {
+			dst = &models.UsageAggregate{
+				WindowStart: w.WindowStart,
+				WindowEnd:   w.WindowEnd,
+				WindowSize:  w.WindowSize,
+				TenantID:    add.TenantID,
+				ServiceID:   add.ServiceID,
+				RevisionID:  add.RevisionID,
+			}
+		}
+		addToRollup(dst, add, before, *existing)
+		if err := tx.Omit(clause.Associations).Save(dst).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func findAggregate(tx *gorm.DB, size string, start time.Time, tenantID, serviceID uuid.UUID, revisionID *uuid.UUID) (*models.UsageAggregate, error) {
+	q := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("window_size = ? AND window_start = ? AND tenant_id = ? AND service_id = ?", size, start, tenantID, serviceID)
+	if revisionID != nil {
+		q = q.Where("revision_id = ?", *revisionID)
+	} else {
+		q = q.Where("revision_id IS NULL")
+	}
+	var agg models.UsageAggregate
+	if err := q.First(&agg).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &agg, nil
+}
+
+// mergeAggregate - агрегат объединения двух наборов событий одного окна
+func mergeAggregate(dst *models.UsageAggregate, add models.UsageAggregate, allocated bool) {
+	dst.AvgDurationMS = weighted(dst.AvgDurationMS, float64(dst.Invocations), add.AvgDurationMS, float64(add.Invocations))
+	dst.P50DurationMS = weighted(dst.P50DurationMS, float64(dst.Invocations), add.P50DurationMS, float64(add.Invocations))
+	dst.P95DurationMS = math.Max(dst.P95DurationMS, add.P95DurationMS)
+	dst.MaxMemoryMB = math.Max(dst.MaxMemoryMB, add.MaxMemoryMB)
+
+	// у агрегатов до появления memory_samples вес - число вызовов
+	samples := float64(dst.MemorySamples)
+	if samples == 0 && dst.AvgMemoryMB > 0 {
+		samples = math.Max(float64(dst.Invocations), 1)
+	}
+	dst.AvgMemoryMB = weighted(dst.AvgMemoryMB, samples, add.AvgMemoryMB, float64(add.MemorySamples))
+	dst.MemorySamples += add.MemorySamples
+	if allocated {
+		dst.TotalMemoryMBHours += add.TotalMemoryMBHours
+	} else {
+		dst.TotalMemoryMBHours = dst.AvgMemoryMB * dst.WindowEnd.Sub(dst.WindowStart).Hours()
+	}
+
+	addSums(dst, add)
+}
+
+// addToRollup переносит в свёртку изменение окна before -> after, вызванное
+// событиями add: правила те же, что в services.RollupService.rollupWindow
+func addToRollup(dst *models.UsageAggregate, add, before, after models.UsageAggregate) {
+	dst.AvgDurationMS = weighted(dst.AvgDurationMS, float64(dst.Invocations), add.AvgDurationMS, float64(add.Invocations))
+	dst.P50DurationMS = weighted(dst.P50DurationMS, float64(dst.Invocations), add.P50DurationMS, float64(add.Invocations))
+	dst.P95DurationMS = math.Max(dst.P95DurationMS, add.P95DurationMS)
+	dst.MaxMemoryMB = math.Max(dst.MaxMemoryMB, add.MaxMemoryMB)
+
+	// средняя память свёртки взвешена длиной окон-источников
+	share := after.WindowEnd.Sub(after.WindowStart).Seconds() / dst.WindowEnd.Sub(dst.WindowStart).Seconds()
+	dst.AvgMemoryMB += (after.AvgMemoryMB - before.AvgMemoryMB) * share
+	dst.TotalMemoryMBHours += after.TotalMemoryMBHours - before.TotalMemoryMBHours
+	dst.MemorySamples += add.MemorySamples
+
+	addSums(dst, add)
+}
+
+func addSums(dst *models.UsageAggregate, add models.UsageAggregate) {
+	dst.Invocations += add.Invocations
+	dst.TotalDurationMS += add.TotalDurationMS
+	dst.BillableDurationMS += add.BillableDurationMS
+	dst.ColdStarts += add.ColdStarts
+	dst.ColdStartInitMS += add.ColdStartInitMS
+	dst.ColdStartGBSeconds += add.ColdStartGBSeconds
+	dst.Errors += add.Errors
+	dst.EgressBytes += add.EgressBytes
+	dst.TotalCPUMS += add.TotalCPUMS
+}
+
+// weighted - взвешенное среднее; без весов - ненулевое из значений
+func weighted(a, wa, b, wb float64) float64 {
+	if wa+wb > 0 {
+		return (a*wa + b*wb) / (wa + wb)
+	}
+	if a == 0 {
+		return b
+	}
+	return a
+}
+
+// MarkWindowComplete отмечает окно посчитанным (повторная отметка обновляет её)
+func MarkWindowComplete(db *gorm.DB, size string, start, end time.Time, source string, rows int) error {
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&models.AggregationWindow{
+		WindowSize:  size,
+		WindowStart: start,
+		WindowEnd:   end,
+		Source:      source,
+		Rows:        rows,
+		CompletedAt: time.Now(),
+	}).Error
+}
+
+// RecordLateWindow учитывает опоздавшие события окна арендатора
+func RecordLateWindow(tx *gorm.DB, size string, start, end time.Time, tenantID uuid.UUID, events int64, now time.Time) (*models.LateWindow, error) {
+	var lw models.LateWindow
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("window_size = ? AND window_start = ? AND tenant_id = ?", size, start, tenantID).
+		First(&lw).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		lw = models.LateWindow{
+			ID:          uuid.New(),
+			WindowSize:  size,
+			WindowStart: start,
+			WindowEnd:   end,
+			TenantID:    tenantID,
+			FirstSeenAt: now,
+		}
+	}
+	lw.Events += events
+	lw.Reaggregations++
+	lw.ReaggregatedAt = now
+	return &lw, tx.Save(&lw).Error
+}
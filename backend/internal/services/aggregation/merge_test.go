@@ -0,0 +1,143 @@
+package aggregation
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/lypolix/FaaS-billing/internal/models"
+)
+
+var minute = time.Date(2026, 1, 7, 12, 5, 0, 0, time.UTC)
+
+// agg - агрегат минутного окна minute
+func agg(inv int64, avgDur, avgMem float64, samples int64, mbHours float64) models.UsageAggregate {
+	return models.UsageAggregate{
+		WindowSize:         "1m",
+		WindowStart:        minute,
+		WindowEnd:          minute.Add(time.Minute),
+		Invocations:        inv,
+		TotalDurationMS:    int64(avgDur) * inv,
+		BillableDurationMS: int64(avgDur) * inv,
+		AvgDurationMS:      avgDur,
+		P50DurationMS:      avgDur,
+		P95DurationMS:      avgDur * 2,
+		MaxMemoryMB:        avgMem * 1.5,
+		AvgMemoryMB:        avgMem,
+		MemorySamples:      samples,
+		TotalMemoryMBHours: mbHours,
+		ColdStarts:         1,
+		ColdStartInitMS:    300,
+		ColdStartGBSeconds: 0.1,
+		Errors:             1,
+		EgressBytes:        1024,
+		TotalCPUMS:         50,
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestMergeAggregate(t *testing.T) {
+	hour := time.Minute.Hours()
+	tests := []struct {
+		name      string
+		dst, add  models.UsageAggregate
+		allocated bool
+
+		avgDur, avgMem, mbHours float64
+		samples                 int64
+	}{
+		{
+			name: "measured memory recomputed from merged average",
+			dst:  agg(10, 100, 100, 10, 100*hour), add: agg(30, 200, 200, 30, 200*hour),
+			avgDur: 175, avgMem: 175, samples: 40, mbHours: 175 * hour,
+		},
+		{
+			name: "allocated memory is summed",
+			dst:  agg(10, 100, 100, 10, 2), add: agg(30, 200, 200, 30, 3), allocated: true,
+			avgDur: 175, avgMem: 175, samples: 40, mbHours: 5,
+		},
+		{
+			name: "rows without memory_samples weighted by invocations",
+			dst:  agg(10, 100, 100, 0, 100*hour), add: agg(10, 100, 200, 10, 200*hour),
+			avgDur: 100, avgMem: 150, samples: 10, mbHours: 150 * hour,
+		},
+		{
+			name: "empty window takes added values",
+			dst:  agg(0, 0, 0, 0, 0), add: agg(5, 80, 64, 5, 64*hour),
+			avgDur: 80, avgMem: 64, samples: 5, mbHours: 64 * hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := tt.dst
+			mergeAggregate(&dst, tt.add, tt.allocated)
+
+			if !near(dst.AvgDurationMS, tt.avgDur) || !near(dst.P50DurationMS, tt.avgDur) {
+				t.Errorf("avg/p50 duration = %v/%v, want %v", dst.AvgDurationMS, dst.P50DurationMS, tt.avgDur)
+			}
+			if !near(dst.AvgMemoryMB, tt.avgMem) || dst.MemorySamples != tt.samples || !near(dst.TotalMemoryMBHours, tt.mbHours) {
+				t.Errorf("memory avg/samples/mb-hours = %v/%d/%v, want %v/%d/%v",
+					dst.AvgMemoryMB, dst.MemorySamples, dst.TotalMemoryMBHours, tt.avgMem, tt.samples, tt.mbHours)
+			}
+			if dst.P95DurationMS != math.Max(tt.dst.P95DurationMS, tt.add.P95DurationMS) ||
+				dst.MaxMemoryMB != math.Max(tt.dst.MaxMemoryMB, tt.add.MaxMemoryMB) {
+				t.Errorf("p95/max memory = %v/%v, want maxima", dst.P95DurationMS, dst.MaxMemoryMB)
+			}
+			if dst.Invocations != tt.dst.Invocations+tt.add.Invocations ||
+				dst.TotalDurationMS != tt.dst.TotalDurationMS+tt.add.TotalDurationMS ||
+				dst.ColdStarts != 2 || dst.ColdStartInitMS != 600 || dst.Errors != 2 ||
+				dst.EgressBytes != 2048 || dst.TotalCPUMS != 100 || !near(dst.ColdStartGBSeconds, 0.2) {
+				t.Errorf("sums not added: %+v", dst)
+			}
+		})
+	}
+}
+
+func TestAddToRollup(t *testing.T) {
+	hourStart := minute.Truncate(time.Hour)
+	rollup := func(inv int64, avgDur, avgMem float64, samples int64, mbHours float64) models.UsageAggregate {
+		r := agg(inv, avgDur, avgMem, samples, mbHours)
+		r.WindowSize, r.WindowStart, r.WindowEnd = "1h", hourStart, hourStart.Add(time.Hour)
+		return r
+	}
+	tests := []struct {
+		name                 string
+		dst                  models.UsageAggregate
+		add, before, after   models.UsageAggregate
+		avgDur, avgMem       float64
+		mbHours              float64
+		samples, invocations int64
+	}{
+		{
+			name:   "late events in an already rolled-up minute",
+			dst:    rollup(10, 100, 60, 10, 1),
+			add:    agg(30, 200, 160, 10, 0),
+			before: agg(10, 100, 100, 10, 0.5), after: agg(40, 175, 160, 20, 0.8),
+			avgDur: 175, avgMem: 61, mbHours: 1.3, samples: 20, invocations: 40,
+		},
+		{
+			name:   "first events of the window in the rollup",
+			dst:    rollup(0, 0, 0, 0, 0),
+			add:    agg(6, 50, 120, 6, 0),
+			before: models.UsageAggregate{WindowStart: minute, WindowEnd: minute.Add(time.Minute)}, after: agg(6, 50, 120, 6, 2),
+			avgDur: 50, avgMem: 2, mbHours: 2, samples: 6, invocations: 6,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := tt.dst
+			addToRollup(&dst, tt.add, tt.before, tt.after)
+
+			if !near(dst.AvgDurationMS, tt.avgDur) || !near(dst.AvgMemoryMB, tt.avgMem) || !near(dst.TotalMemoryMBHours, tt.mbHours) {
+				t.Errorf("avg duration/avg memory/mb-hours = %v/%v/%v, want %v/%v/%v",
+					dst.AvgDurationMS, dst.AvgMemoryMB, dst.TotalMemoryMBHours, tt.avgDur, tt.avgMem, tt.mbHours)
+			}
+			if dst.MemorySamples != tt.samples || dst.Invocations != tt.invocations {
+				t.Errorf("samples/invocations = %d/%d, want %d/%d", dst.MemorySamples, dst.Invocations, tt.samples, tt.invocations)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package aggregation
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/lypolix/FaaS-billing/internal/models"
+)
+
+// UsageRules - правила учёта использования из плана арендатора, которые
+// применяются при агрегации (а не при расчёте счёта)
+type UsageRules struct {
+	MemoryMode    string
+	MinDurationMS int
+	RoundingMS    int
+}
+
+// DefaultUsageRules - для арендаторов без плана: память по замерам,
+// длительность без округления
+var DefaultUsageRules = UsageRules{MemoryMode: models.MemoryBillingMeasured, RoundingMS: 1}
+
+// RulesFunc возвращает правила плана, действовавшего у арендатора в момент at
+type RulesFunc func(tenantID uuid.UUID, at time.Time) UsageRules
+
+// ParseWindowSize - длительность поддерживаемого размера окна
+func ParseWindowSize(s string) (time.Duration, error) {
+	switch s {
+	case "1m":
+		return time.Minute, nil
+	case "5m":
+		return 5 * time.Minute, nil
+	case "1h":
+		return time.Hour, nil
+	case "1d":
+		return 24 * time.Hour, nil
+	default:
+		return 0, fmt.Errorf("unsupported window size: %s", s)
+	}
+}
@@ -1,13 +1,19 @@
 package services
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lypolix/FaaS-billing/internal/i18n"
 	"github.com/lypolix/FaaS-billing/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type BillingService struct {
@@ -18,110 +24,240 @@ func NewBillingService(db *gorm.DB) *BillingService {
 	return &BillingService{db: db}
 }
 
-// CalculateBill - основная функция расчёта стоимости по формулам 
+// CalculateBill - основная функция расчёта стоимости по формулам
 func (s *BillingService) CalculateBill(tenantID string, startTime, endTime time.Time) (*models.BillingResult, error) {
-    // 1) Загружаем tenant и берём выбранный plan_id
-    var tenant models.Tenant
-    if err := s.db.First(&tenant, "id = ?", tenantID).Error; err != nil {
-        return nil, fmt.Errorf("tenant not found: %w", err)
-    }
-
-    if tenant.PricingPlanID == nil {
-        return nil, fmt.Errorf("tenant has no pricing plan assigned")
-    }
-
-    // 2) Загружаем тарифный план строго по PricingPlanID
-    var pricingPlan models.PricingPlan
-    if err := s.db.First(&pricingPlan, "id = ? AND active = ?", *tenant.PricingPlanID, true).Error; err != nil {
-        return nil, fmt.Errorf("pricing plan not found or inactive: %w", err)
-    }
-
-    // 3) Получаем агрегированные данные за период
-    var aggregates []models.UsageAggregate
-    err := s.db.Where(
-        "tenant_id = ? AND window_start >= ? AND window_end <= ?",
-        tenantID, startTime, endTime,
-    ).Find(&aggregates).Error
-    if err != nil {
-        return nil, fmt.Errorf("failed to get usage aggregates: %w", err)
-    }
-
-    // 4) Считаем общие показатели
-    totals := s.calculateTotals(aggregates)
-
-    // 5) Применяем биллинговые формулы
-    result := &models.BillingResult{
-        TenantID:    uuid.MustParse(tenantID),
-        PeriodStart: startTime,
-        PeriodEnd:   endTime,
-        Currency:    pricingPlan.Currency,
-        LineItems:   []models.BillingLineItem{},
-    }
-
-    invocationsItem := s.calculateInvocationsCost(totals.TotalInvocations, pricingPlan)
-    result.LineItems = append(result.LineItems, invocationsItem)
-
-    computeItem := s.calculateComputeCost(totals.TotalGBHours, pricingPlan)
-    result.LineItems = append(result.LineItems, computeItem)
-
-    if totals.TotalEgressGB > 0 {
-        egressItem := s.calculateEgressCost(totals.TotalEgressGB, pricingPlan)
-        result.LineItems = append(result.LineItems, egressItem)
-    }
-
-    coldStartsItem := models.BillingLineItem{
-        Description:    "Холодные старты",
-        Quantity:       float64(totals.TotalColdStarts),
-        UnitPrice:      pricingPlan.PricePerColdStart,
-        FreeTierUsed:   float64(totals.TotalColdStarts), 
-        BillableAmount: 0,
-        TotalCost:      0,
-        Currency:       pricingPlan.Currency,
-    }
-    result.LineItems = append(result.LineItems, coldStartsItem)
-
-    var totalCost float64
-    for _, item := range result.LineItems {
-        totalCost += item.TotalCost
-    }
-    result.TotalCost = math.Round(totalCost*100) / 100 
-    
-
-    result.FreeTierSummary = models.FreeTierSummary{
-        InvocationsUsed:  totals.TotalInvocations,
-        InvocationsLimit: pricingPlan.FreeTierInvocations,
-        GBHoursUsed:      totals.TotalGBHours,
-        GBHoursLimit:     pricingPlan.FreeTierGBHours,
-        EgressGBUsed:     totals.TotalEgressGB,
-        EgressGBLimit:    pricingPlan.FreeTierEgressGB,
-    }
-
-    return result, nil
+	calc, err := s.calculate(tenantID, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	return calc.Result, nil
+}
+
+// billCalculation - результат расчёта вместе с исходными данными,
+// которые замораживаются в счёте при финализации
+type billCalculation struct {
+	Result     *models.BillingResult
+	Plan       models.PricingPlan // версия, действующая на конец периода
+	Segments   []planSegment
+	Totals     UsageTotals
+	Aggregates int
+}
+
+func (s *BillingService) calculate(tenantID string, startTime, endTime time.Time) (*billCalculation, error) {
+	// 1) Загружаем tenant
+	var tenant models.Tenant
+	if err := s.db.First(&tenant, "id = ?", tenantID).Error; err != nil {
+		return nil, fmt.Errorf("tenant not found: %w", err)
+	}
+
+	// 2) Версии планов, действовавшие в периоде (по истории назначений)
+	segments, err := planSegments(s.db, tenant, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	pricingPlan := segments[len(segments)-1].Plan
+
+	// 3) Получаем агрегированные данные за период
+	var aggregates []models.UsageAggregate
+	err = s.db.Scopes(models.CanonicalAggregates).Where(
+		"tenant_id = ? AND window_start >= ? AND window_end <= ?",
+		tenantID, startTime, endTime,
+	).Find(&aggregates).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage aggregates: %w", err)
+	}
+
+	// 4) Считаем общие показатели
+	totals := s.calculateTotals(aggregates)
+
+	// 5) Применяем биллинговые формулы к каждому отрезку; free tier
+	// расходуется в хронологическом порядке
+	result := &models.BillingResult{
+		TenantID:    tenant.ID,
+		PeriodStart: startTime,
+		PeriodEnd:   endTime,
+		Currency:    pricingPlan.Currency,
+		LineItems:   []models.BillingLineItem{},
+	}
+
+	var used UsageTotals
+	for _, seg := range segments {
+		var segAggregates []models.UsageAggregate
+		for _, agg := range aggregates {
+			if !agg.WindowStart.Before(seg.Start) && agg.WindowStart.Before(seg.End) {
+				segAggregates = append(segAggregates, agg)
+			}
+		}
+		segTotals := s.calculateTotals(segAggregates)
+
+		part := &models.BillingResult{
+			Currency:  seg.Plan.Currency,
+			LineItems: s.priceUsage(segTotals, remainingFreeTier(seg.Plan, used)),
+		}
+		used = addTotals(used, segTotals)
+
+		// 6) Пересчёт в валюту арендатора по курсу на конец отрезка; курс
+		// сохраняется в строках отрезка, в шапке счёта - курс последнего
+		if currency := normalizeCurrency(tenant.Currency); currency != normalizeCurrency(seg.Plan.Currency) {
+			rate, rateDate, err := NewCurrencyService(s.db).PeriodRate(seg.Plan.Currency, currency, seg.End)
+			if err != nil {
+				return nil, err
+			}
+			convertResult(part, currency, rate, rateDate)
+			result.Currency = part.Currency
+			result.SourceCurrency = part.SourceCurrency
+			result.ExchangeRate = part.ExchangeRate
+			result.ExchangeRateDate = part.ExchangeRateDate
+		}
+
+		if len(segments) > 1 {
+			seg := seg
+			for i := range part.LineItems {
+				it := &part.LineItems[i]
+				it.PeriodStart, it.PeriodEnd = &seg.Start, &seg.End
+				it.PricingPlanID, it.PlanVersion = &seg.Plan.ID, seg.Plan.Version
+			}
+		}
+		result.LineItems = append(result.LineItems, part.LineItems...)
+	}
+
+	// Скидки и минимальный платёж по контракту
+	contract, err := activeContract(s.db, tenant.ID, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	if contract != nil {
+		applyContract(result, contract, startTime, endTime)
+	}
+
+	// Доначисления за опоздавшее использование прошлых счетов
+	if err := applyAdjustments(s.db, result, startTime); err != nil {
+		return nil, err
+	}
+
+	// Кредиты и предоплата списываются после free tier и скидок, до налога
+	if err := applyCredits(s.db, result); err != nil {
+		return nil, err
+	}
+
+	var totalCost float64
+	for _, item := range result.LineItems {
+		totalCost += item.TotalCost
+	}
+
+	// 7) Налог по профилю арендатора
+	taxProfile, err := loadTaxProfile(s.db, tenant)
+	if err != nil {
+		return nil, err
+	}
+	result.Subtotal, _, result.TotalCost, result.Tax, err = applyTax(totalCost, taxProfile, pricingPlan.PricesIncludeTax)
+	if err != nil {
+		return nil, err
+	}
+
+	result.FreeTierSummary = models.FreeTierSummary{
+		InvocationsUsed:  totals.TotalInvocations,
+		InvocationsLimit: pricingPlan.FreeTierInvocations,
+		GBHoursUsed:      totals.TotalGBHours,
+		GBHoursLimit:     pricingPlan.FreeTierGBHours,
+		EgressGBUsed:     totals.TotalEgressGB,
+		EgressGBLimit:    pricingPlan.FreeTierEgressGB,
+		ColdStartsUsed:   totals.TotalColdStarts,
+		ColdStartsLimit:  pricingPlan.FreeTierColdStarts,
+		VCPUHoursUsed:    totals.TotalVCPUHours,
+		VCPUHoursLimit:   pricingPlan.FreeTierVCPUHours,
+	}
+
+	return &billCalculation{
+		Result:     result,
+		Plan:       pricingPlan,
+		Segments:   segments,
+		Totals:     totals,
+		Aggregates: len(aggregates),
+	}, nil
+}
+
+// priceUsage - строки счёта за использование по одному плану
+func (s *BillingService) priceUsage(totals UsageTotals, plan models.PricingPlan) []models.BillingLineItem {
+	items := []models.BillingLineItem{
+		s.calculateInvocationsCost(totals.TotalInvocations, plan),
+		s.calculateComputeCost(totals.TotalGBHours, plan),
+	}
+	if totals.TotalEgressGB > 0 {
+		items = append(items, s.calculateEgressCost(totals.TotalEgressGB, plan))
+	}
+	// CPU - только в планах, где оно тарифицируется отдельно
+	if plan.PricePerVCPUHour > 0 {
+		items = append(items, s.calculateVCPUCost(totals.TotalVCPUHours, plan))
+	}
+	items = append(items, s.calculateColdStartsCost(totals.TotalColdStarts, plan))
+	// надбавка за инициализацию - только если она есть в плане
+	if plan.PricePerColdStartGBSecond > 0 {
+		items = append(items, s.calculateColdStartInitCost(totals.TotalColdStartGBSeconds, plan))
+	}
+	return items
+}
+
+// remainingFreeTier уменьшает месячный free tier плана на объём,
+// израсходованный на предыдущих отрезках периода
+func remainingFreeTier(plan models.PricingPlan, used UsageTotals) models.PricingPlan {
+	plan.FreeTierInvocations = int64(math.Max(0, float64(plan.FreeTierInvocations-used.TotalInvocations)))
+	plan.FreeTierGBHours = math.Max(0, plan.FreeTierGBHours-used.TotalGBHours)
+	plan.FreeTierEgressGB = math.Max(0, plan.FreeTierEgressGB-used.TotalEgressGB)
+	plan.FreeTierColdStarts = int64(math.Max(0, float64(plan.FreeTierColdStarts-used.TotalColdStarts)))
+	plan.FreeTierVCPUHours = math.Max(0, plan.FreeTierVCPUHours-used.TotalVCPUHours)
+	return plan
+}
+
+func addTotals(a, b UsageTotals) UsageTotals {
+	return UsageTotals{
+		TotalInvocations: a.TotalInvocations + b.TotalInvocations,
+		TotalGBHours:     a.TotalGBHours + b.TotalGBHours,
+		TotalEgressGB:    a.TotalEgressGB + b.TotalEgressGB,
+		TotalColdStarts:  a.TotalColdStarts + b.TotalColdStarts,
+
+		TotalColdStartGBSeconds: a.TotalColdStartGBSeconds + b.TotalColdStartGBSeconds,
+		TotalVCPUHours:          a.TotalVCPUHours + b.TotalVCPUHours,
+
+		TotalDurationMS:    a.TotalDurationMS + b.TotalDurationMS,
+		BillableDurationMS: a.BillableDurationMS + b.BillableDurationMS,
+	}
 }
 
 type UsageTotals struct {
-	TotalInvocations int64
-	TotalGBHours     float64
-	TotalEgressGB    float64
-	TotalColdStarts  int64
+	TotalInvocations int64   `json:"total_invocations"`
+	TotalGBHours     float64 `json:"total_gb_hours"`
+	TotalEgressGB    float64 `json:"total_egress_gb"`
+	TotalColdStarts  int64   `json:"total_cold_starts"`
+
+	TotalColdStartGBSeconds float64 `json:"total_cold_start_gb_seconds"`
+	TotalVCPUHours          float64 `json:"total_vcpu_hours"`
+
+	// фактическая и оплачиваемая (минимум и округление плана) длительность
+	TotalDurationMS    int64 `json:"total_duration_ms"`
+	BillableDurationMS int64 `json:"billable_duration_ms"`
 }
 
 func (s *BillingService) calculateTotals(aggregates []models.UsageAggregate) UsageTotals {
 	totals := UsageTotals{}
-	
+
 	for _, agg := range aggregates {
 		totals.TotalInvocations += agg.Invocations
 		totals.TotalColdStarts += int64(agg.ColdStarts)
-		
+		totals.TotalColdStartGBSeconds += agg.ColdStartGBSeconds
+		totals.TotalVCPUHours += float64(agg.TotalCPUMS) / 3_600_000.0
+		totals.TotalDurationMS += agg.TotalDurationMS
+		totals.BillableDurationMS += agg.BillableDurationMS
+
 		// Переводим МБ×час в ГБ×час
 		gbHours := agg.TotalMemoryMBHours / 1024.0
 		totals.TotalGBHours += gbHours
-		
+
 		// Переводим bytes в GB
 		egressGB := float64(agg.EgressBytes) / (1024.0 * 1024.0 * 1024.0)
 		totals.TotalEgressGB += egressGB
 	}
-	
+
 	return totals
 }
 
@@ -129,13 +265,15 @@ func (s *BillingService) calculateTotals(aggregates []models.UsageAggregate) Usa
 func (s *BillingService) calculateInvocationsCost(totalInvocations int64, plan models.PricingPlan) models.BillingLineItem {
 	freeTierUsed := int64(math.Min(float64(totalInvocations), float64(plan.FreeTierInvocations)))
 	billableInvocations := int64(math.Max(0, float64(totalInvocations-plan.FreeTierInvocations)))
-	
+
 	// Цена за миллион
 	billableMillions := float64(billableInvocations) / 1_000_000.0
 	cost := billableMillions * plan.PricePerMillionInvocations
-	
+
 	return models.BillingLineItem{
-		Description:    "Вызовы функций",
+		Code:           models.LineItemInvocations,
+		Unit:           "invocation",
+		Description:    lineItemDescription(models.LineItemInvocations),
 		Quantity:       float64(totalInvocations),
 		UnitPrice:      plan.PricePerMillionInvocations, // за миллион
 		FreeTierUsed:   float64(freeTierUsed),
@@ -149,11 +287,13 @@ func (s *BillingService) calculateInvocationsCost(totalInvocations int64, plan m
 func (s *BillingService) calculateComputeCost(totalGBHours float64, plan models.PricingPlan) models.BillingLineItem {
 	freeTierUsed := math.Min(totalGBHours, plan.FreeTierGBHours)
 	billableGBHours := math.Max(0, totalGBHours-plan.FreeTierGBHours)
-	
+
 	cost := billableGBHours * plan.PricePerGBHour
-	
+
 	return models.BillingLineItem{
-		Description:    "Время выполнения функций (ГБ×час)",
+		Code:           models.LineItemComputeGBHours,
+		Unit:           "gb_hour",
+		Description:    lineItemDescription(models.LineItemComputeGBHours),
 		Quantity:       totalGBHours,
 		UnitPrice:      plan.PricePerGBHour,
 		FreeTierUsed:   freeTierUsed,
@@ -167,11 +307,13 @@ func (s *BillingService) calculateComputeCost(totalGBHours float64, plan models.
 func (s *BillingService) calculateEgressCost(totalEgressGB float64, plan models.PricingPlan) models.BillingLineItem {
 	freeTierUsed := math.Min(totalEgressGB, plan.FreeTierEgressGB)
 	billableEgressGB := math.Max(0, totalEgressGB-plan.FreeTierEgressGB)
-	
+
 	cost := billableEgressGB * plan.PricePerGBEgress
-	
+
 	return models.BillingLineItem{
-		Description:    "Исходящий трафик",
+		Code:           models.LineItemEgressGB,
+		Unit:           "gb",
+		Description:    lineItemDescription(models.LineItemEgressGB),
 		Quantity:       totalEgressGB,
 		UnitPrice:      plan.PricePerGBEgress,
 		FreeTierUsed:   freeTierUsed,
@@ -181,25 +323,217 @@ func (s *BillingService) calculateEgressCost(totalEgressGB float64, plan models.
 	}
 }
 
-// Сохранить счёт в БД
-func (s *BillingService) SaveBill(result *models.BillingResult) (*models.Bill, error) {
-	lineItemsJSON := make(models.JSONB)
-	lineItemsJSON["items"] = result.LineItems
-	lineItemsJSON["free_tier"] = result.FreeTierSummary
-	
+// Процессорное время в vCPU×час сверх free tier
+func (s *BillingService) calculateVCPUCost(vcpuHours float64, plan models.PricingPlan) models.BillingLineItem {
+	freeTierUsed := math.Min(vcpuHours, plan.FreeTierVCPUHours)
+	billable := math.Max(0, vcpuHours-plan.FreeTierVCPUHours)
+
+	cost := billable * plan.PricePerVCPUHour
+
+	return models.BillingLineItem{
+		Code:           models.LineItemVCPUHours,
+		Unit:           "vcpu_hour",
+		Description:    lineItemDescription(models.LineItemVCPUHours),
+		Quantity:       vcpuHours,
+		UnitPrice:      plan.PricePerVCPUHour,
+		FreeTierUsed:   freeTierUsed,
+		BillableAmount: billable,
+		TotalCost:      math.Round(cost*100) / 100,
+		Currency:       plan.Currency,
+	}
+}
+
+// Холодные старты сверх бесплатного лимита плана
+func (s *BillingService) calculateColdStartsCost(totalColdStarts int64, plan models.PricingPlan) models.BillingLineItem {
+	freeTierUsed := int64(math.Min(float64(totalColdStarts), float64(plan.FreeTierColdStarts)))
+	billable := totalColdStarts - freeTierUsed
+
+	cost := float64(billable) * plan.PricePerColdStart
+
+	return models.BillingLineItem{
+		Code:           models.LineItemColdStarts,
+		Unit:           "cold_start",
+		Description:    lineItemDescription(models.LineItemColdStarts),
+		Quantity:       float64(totalColdStarts),
+		UnitPrice:      plan.PricePerColdStart,
+		FreeTierUsed:   float64(freeTierUsed),
+		BillableAmount: float64(billable),
+		TotalCost:      math.Round(cost*100) / 100,
+		Currency:       plan.Currency,
+	}
+}
+
+// Надбавка за время инициализации холодных стартов, ГБ×с; без free tier
+func (s *BillingService) calculateColdStartInitCost(gbSeconds float64, plan models.PricingPlan) models.BillingLineItem {
+	cost := gbSeconds * plan.PricePerColdStartGBSecond
+
+	return models.BillingLineItem{
+		Code:           models.LineItemColdStartInit,
+		Unit:           "gb_second",
+		Description:    lineItemDescription(models.LineItemColdStartInit),
+		Quantity:       gbSeconds,
+		UnitPrice:      plan.PricePerColdStartGBSecond,
+		BillableAmount: gbSeconds,
+		TotalCost:      math.Round(cost*100) / 100,
+		Currency:       plan.Currency,
+	}
+}
+
+// Политики обработки пересечения периода нового счёта с существующими
+const (
+	OverlapReject    = "reject"    // вернуть ErrBillOverlap
+	OverlapSupersede = "supersede" // аннулировать пересекающиеся черновики и создать новый
+)
+
+// Сохранить счёт в БД. Пересечение с не аннулированными счетами арендатора
+// обрабатывается согласно policy; выставленные (final, paid) счета supersede
+// не трогает - их аннулируют явно через VoidBill. В БД дополнительно
+// действует exclusion constraint bills_no_overlap.
+func (s *BillingService) SaveBill(result *models.BillingResult, policy string) (*models.Bill, error) {
+	if policy == "" {
+		policy = OverlapReject
+	}
+	if policy != OverlapReject && policy != OverlapSupersede {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidOverlapPolicy, policy)
+	}
+
 	bill := &models.Bill{
 		TenantID:    result.TenantID,
 		PeriodStart: result.PeriodStart,
 		PeriodEnd:   result.PeriodEnd,
-		TotalAmount: result.TotalCost,
-		Currency:    result.Currency,
-		Status:      "draft",
-		LineItems:   lineItemsJSON,
+		Status:      models.BillStatusDraft,
 		CreatedAt:   time.Now(),
 	}
-	
-	err := s.db.Create(bill).Error
-	return bill, err
+	applyResult(bill, result)
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		overlapping, err := findOverlappingBills(tx, result.TenantID, result.PeriodStart, result.PeriodEnd)
+		if err != nil {
+			return err
+		}
+		if len(overlapping) > 0 {
+			if policy == OverlapReject {
+				return overlapError(overlapping)
+			}
+			for _, b := range overlapping {
+				if b.Status != models.BillStatusDraft {
+					return overlapError([]models.Bill{b})
+				}
+			}
+			for _, b := range overlapping {
+				if err := tx.Model(&models.Bill{}).Where("id = ?", b.ID).Updates(map[string]interface{}{
+					"status":      models.BillStatusVoid,
+					"voided_at":   time.Now(),
+					"void_reason": "superseded by a newly generated bill",
+				}).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := tx.Create(bill).Error; err != nil {
+			if isExclusionViolation(err) {
+				return fmt.Errorf("%w: concurrent bill for the same period", ErrBillOverlap)
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bill, nil
+}
+
+// Пересекающиеся полуинтервалы [start, end) не аннулированных счетов арендатора
+func findOverlappingBills(tx *gorm.DB, tenantID uuid.UUID, start, end time.Time) ([]models.Bill, error) {
+	var bills []models.Bill
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("tenant_id = ? AND status <> ? AND period_start < ? AND period_end > ?",
+			tenantID, models.BillStatusVoid, end, start).
+		Order("period_start").
+		Find(&bills).Error
+	return bills, err
+}
+
+func overlapError(bills []models.Bill) error {
+	ids := make([]string, 0, len(bills))
+	for _, b := range bills {
+		ids = append(ids, fmt.Sprintf("%s (%s)", b.ID, b.Status))
+	}
+	return fmt.Errorf("%w: %s", ErrBillOverlap, strings.Join(ids, ", "))
+}
+
+func isExclusionViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23P01"
+}
+
+// applyResult переносит суммы и строки расчёта в счёт
+func applyResult(bill *models.Bill, result *models.BillingResult) {
+	bill.Subtotal = result.Subtotal
+	bill.TaxAmount = result.Tax.Amount
+	bill.TotalAmount = result.TotalCost
+	bill.Currency = result.Currency
+	bill.SourceCurrency = result.SourceCurrency
+	bill.ExchangeRate = result.ExchangeRate
+	bill.ExchangeRateDate = result.ExchangeRateDate
+	bill.TaxProfileID = result.Tax.ProfileID
+	bill.TaxMode = result.Tax.Mode
+	bill.TaxRate = result.Tax.Rate
+	bill.TaxInclusive = result.Tax.Inclusive
+	bill.ContractID = result.ContractID
+	bill.CreditsApplied = result.Credits.Applied
+	bill.BalanceRemaining = result.Credits.BalanceRemaining
+	bill.LineItems = lineItemsJSON(result)
+}
+
+// Описание по умолчанию сохраняется в счёте для совместимости; при выдаче
+// через API оно переводится по коду (см. LocalizeLineItems)
+func lineItemDescription(code string) string {
+	return i18n.T(i18n.DefaultLocale, "line_item."+code)
+}
+
+// LocalizeLineItems подставляет описания строк на нужной локали
+func LocalizeLineItems(items []models.BillingLineItem, locale string) {
+	for i := range items {
+		items[i].Description = i18n.LineItemLabel(locale, items[i].Code, items[i].AppliesTo, items[i].Description)
+	}
+}
+
+// LocalizeBill переводит строки, сохранённые в line_items счёта
+func LocalizeBill(bill *models.Bill, locale string) {
+	if _, ok := bill.LineItems["items"]; !ok {
+		return
+	}
+	items, err := billItems(*bill)
+	if err != nil {
+		return
+	}
+	LocalizeLineItems(items, locale)
+	bill.LineItems["items"] = items
+}
+
+// billItems - строки, сохранённые в line_items счёта
+func billItems(bill models.Bill) ([]models.BillingLineItem, error) {
+	raw, ok := bill.LineItems["items"]
+	if !ok {
+		return nil, nil
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var items []models.BillingLineItem
+	err = json.Unmarshal(b, &items)
+	return items, err
+}
+
+func lineItemsJSON(result *models.BillingResult) models.JSONB {
+	lineItems := make(models.JSONB)
+	lineItems["items"] = result.LineItems
+	lineItems["free_tier"] = result.FreeTierSummary
+	return lineItems
 }
 
 // Демонстрация расчёта как в документации Yandex
@@ -207,22 +541,22 @@ func (s *BillingService) ExampleCalculation() *models.BillingResult {
 	// Пример из документации:
 	// Память: 512 МБ, Вызовы: 10,000,000, Время: 800 мс каждый
 	// Результат должен быть: 6,660.44 ₽
-	
+
 	memoryMB := 512.0
 	invocations := int64(10_000_000)
 	durationMS := 800.0
-	
+
 	// Переводим в ГБ×час
 	gbHours := (memoryMB / 1024.0) * (durationMS / 3_600_000.0) * float64(invocations)
-	
+
 	plan := models.PricingPlan{
 		PricePerMillionInvocations: 17.28,
-		PricePerGBHour:            5.9076,
-		FreeTierInvocations:       1_000_000,
-		FreeTierGBHours:           10.0,
-		Currency:                  "RUB",
+		PricePerGBHour:             5.9076,
+		FreeTierInvocations:        1_000_000,
+		FreeTierGBHours:            10.0,
+		Currency:                   "RUB",
 	}
-	
+
 	result := &models.BillingResult{
 		Currency: "RUB",
 		LineItems: []models.BillingLineItem{
@@ -230,8 +564,9 @@ func (s *BillingService) ExampleCalculation() *models.BillingResult {
 			s.calculateComputeCost(gbHours, plan),
 		},
 	}
-	
+
 	result.TotalCost = result.LineItems[0].TotalCost + result.LineItems[1].TotalCost
-	
+	result.Subtotal = result.TotalCost
+
 	return result
 }
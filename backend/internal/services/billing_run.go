@@ -0,0 +1,233 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lypolix/FaaS-billing/internal/models"
+	"gorm.io/gorm"
+)
+
+// Исходы обработки арендатора в рамках запуска
+const (
+	RunOutcomeFinalized = "finalized"
+	RunOutcomeSkipped   = "skipped"
+	RunOutcomeFailed    = "failed"
+)
+
+// BillingRunService закрывает календарный месяц: догоняет агрегацию,
+// формирует и финализирует счёт по каждому арендатору. Повторный запуск
+// за тот же месяц безопасен: уже финализированные счета пропускаются,
+// черновики финализируются.
+type BillingRunService struct {
+	db      *gorm.DB
+	billing *BillingService
+	metrics *MetricsService
+}
+
+func NewBillingRunService(db *gorm.DB) *BillingRunService {
+	return &BillingRunService{
+		db:      db,
+		billing: NewBillingService(db),
+		metrics: NewMetricsService(db),
+	}
+}
+
+type BillingRunOptions struct {
+	Month      string     // "2006-01"; пусто = предыдущий месяц в таймзоне арендатора
+	TenantID   *uuid.UUID // ограничить запуск одним арендатором
+	WindowSize string     // окно догоняющей агрегации, по умолчанию "1m"
+	Now        time.Time  // точка отсчёта для "предыдущего месяца", по умолчанию time.Now()
+}
+
+func (s *BillingRunService) Run(opts BillingRunOptions) (*models.BillingRun, error) {
+	if opts.WindowSize == "" {
+		opts.WindowSize = "1m"
+	}
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	if opts.Month != "" {
+		if _, err := time.Parse("2006-01", opts.Month); err != nil {
+			return nil, fmt.Errorf("invalid month %q, expected YYYY-MM: %w", opts.Month, err)
+		}
+	}
+
+	var tenants []models.Tenant
+	q := s.db.Order("created_at")
+	if opts.TenantID != nil {
+		q = q.Where("id = ?", *opts.TenantID)
+	}
+	if err := q.Find(&tenants).Error; err != nil {
+		return nil, fmt.Errorf("failed to load tenants: %w", err)
+	}
+
+	run := &models.BillingRun{
+		ID:        uuid.New(),
+		Month:     opts.Month,
+		Status:    "running",
+		StartedAt: time.Now(),
+	}
+	if err := s.db.Create(run).Error; err != nil {
+		return nil, fmt.Errorf("failed to create billing run: %w", err)
+	}
+
+	for _, t := range tenants {
+		item := s.runTenant(t, opts)
+		item.RunID = run.ID
+		if err := s.db.Create(&item).Error; err != nil {
+			log.Printf("billing-run: failed to record outcome for tenant %s: %v", t.ID, err)
+		}
+		switch item.Outcome {
+		case RunOutcomeFinalized:
+			run.Succeeded++
+		case RunOutcomeSkipped:
+			run.Skipped++
+		default:
+			run.Failed++
+		}
+		run.Items = append(run.Items, item)
+	}
+
+	finished := time.Now()
+	run.FinishedAt = &finished
+	switch {
+	case run.Failed == 0:
+		run.Status = "completed"
+	case run.Succeeded+run.Skipped == 0:
+		run.Status = "failed"
+	default:
+		run.Status = "partial"
+	}
+	if err := s.db.Omit("Items").Save(run).Error; err != nil {
+		return run, fmt.Errorf("failed to update billing run: %w", err)
+	}
+	return run, nil
+}
+
+func (s *BillingRunService) runTenant(t models.Tenant, opts BillingRunOptions) models.BillingRunItem {
+	start, end, month := tenantMonth(t, opts.Month, opts.Now)
+	item := models.BillingRunItem{
+		TenantID:    t.ID,
+		Month:       month,
+		PeriodStart: start,
+		PeriodEnd:   end,
+	}
+	fail := func(err error) models.BillingRunItem {
+		item.Outcome = RunOutcomeFailed
+		item.Message = err.Error()
+		return item
+	}
+
+	if end.After(opts.Now) {
+		item.Outcome = RunOutcomeSkipped
+		item.Message = "month is not closed yet"
+		return item
+	}
+	if !t.CreatedAt.IsZero() && !t.CreatedAt.Before(end) {
+		item.Outcome = RunOutcomeSkipped
+		item.Message = "tenant created after period end"
+		return item
+	}
+
+	existing, err := s.existingBill(t.ID, start, end)
+	if err != nil {
+		return fail(err)
+	}
+	if existing != nil && existing.Status != models.BillStatusDraft {
+		item.Outcome = RunOutcomeSkipped
+		item.BillID = &existing.ID
+		item.Message = "bill already " + existing.Status
+		return item
+	}
+
+	// догоняем агрегацию сырых данных за месяц
+	if err := s.metrics.AggregateTenantMetrics(t.ID, start, end, opts.WindowSize); err != nil {
+		return fail(fmt.Errorf("aggregation: %w", err))
+	}
+
+	billID := uuid.Nil
+	if existing != nil {
+		billID = existing.ID
+	} else {
+		result, err := s.billing.CalculateBill(t.ID.String(), start, end)
+		if err != nil {
+			return fail(err)
+		}
+		bill, err := s.billing.SaveBill(result, OverlapReject)
+		if err != nil {
+			return fail(err)
+		}
+		billID = bill.ID
+	}
+
+	bill, err := s.billing.FinalizeBill(billID)
+	if err != nil {
+		item.BillID = &billID
+		return fail(err)
+	}
+	item.Outcome = RunOutcomeFinalized
+	item.BillID = &bill.ID
+	if bill.InvoiceNumber != nil {
+		item.Message = *bill.InvoiceNumber
+	}
+	return item
+}
+
+// Не аннулированный счёт арендатора ровно за указанный период
+func (s *BillingRunService) existingBill(tenantID uuid.UUID, start, end time.Time) (*models.Bill, error) {
+	var bill models.Bill
+	err := s.db.Where("tenant_id = ? AND period_start = ? AND period_end = ? AND status <> ?",
+		tenantID, start, end, models.BillStatusVoid).
+		First(&bill).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &bill, nil
+}
+
+// tenantMonth возвращает границы календарного месяца в таймзоне арендатора.
+// Если month пуст, берётся месяц, предшествующий now.
+func tenantMonth(t models.Tenant, month string, now time.Time) (time.Time, time.Time, string) {
+	loc, err := time.LoadLocation(t.Timezone)
+	if err != nil || t.Timezone == "" {
+		loc = time.UTC
+	}
+
+	var start time.Time
+	if month != "" {
+		m, _ := time.Parse("2006-01", month)
+		start = time.Date(m.Year(), m.Month(), 1, 0, 0, 0, 0, loc)
+	} else {
+		local := now.In(loc)
+		start = time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, loc).AddDate(0, -1, 0)
+	}
+	end := start.AddDate(0, 1, 0)
+	return start, end, start.Format("2006-01")
+}
+
+// MonthsBetween перечисляет месяцы "YYYY-MM" от from до to включительно
+func MonthsBetween(from, to string) ([]string, error) {
+	f, err := time.Parse("2006-01", from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid month %q: %w", from, err)
+	}
+	t, err := time.Parse("2006-01", to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid month %q: %w", to, err)
+	}
+	if t.Before(f) {
+		return nil, fmt.Errorf("month range is reversed: %s > %s", from, to)
+	}
+	var out []string
+	for m := f; !m.After(t); m = m.AddDate(0, 1, 0) {
+		out = append(out, m.Format("2006-01"))
+	}
+	return out, nil
+}
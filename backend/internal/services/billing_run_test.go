@@ -0,0 +1,51 @@
+package services
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/lypolix/FaaS-billing/internal/models"
+)
+
+func TestTenantMonth(t *testing.T) {
+	tests := []struct {
+		name      string
+		timezone  string
+		month     string
+		now       time.Time
+		wantMonth string
+		wantStart string // RFC3339
+		wantEnd   string
+	}{
+		{
+			name: "previous month on the 31st", now: time.Date(2026, 3, 31, 10, 0, 0, 0, time.UTC),
+			wantMonth: "2026-02", wantStart: "2026-02-01T00:00:00Z", wantEnd: "2026-03-01T00:00:00Z",
+		},
+		{
+			name: "previous month on march 1st", now: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+			wantMonth: "2026-02", wantStart: "2026-02-01T00:00:00Z", wantEnd: "2026-03-01T00:00:00Z",
+		},
+		{
+			name: "tenant already in the next month", timezone: "Europe/Moscow", now: time.Date(2026, 3, 31, 22, 0, 0, 0, time.UTC),
+			wantMonth: "2026-03", wantStart: "2026-03-01T00:00:00+03:00", wantEnd: "2026-04-01T00:00:00+03:00",
+		},
+		{
+			name: "explicit month in tenant timezone", timezone: "Asia/Tokyo", month: "2026-12", now: time.Date(2027, 5, 1, 0, 0, 0, 0, time.UTC),
+			wantMonth: "2026-12", wantStart: "2026-12-01T00:00:00+09:00", wantEnd: "2027-01-01T00:00:00+09:00",
+		},
+		{
+			name: "unknown timezone falls back to utc", timezone: "Mars/Olympus", month: "2026-01",
+			wantMonth: "2026-01", wantStart: "2026-01-01T00:00:00Z", wantEnd: "2026-02-01T00:00:00Z",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, month := tenantMonth(models.Tenant{Timezone: tt.timezone}, tt.month, tt.now)
+			if month != tt.wantMonth || start.Format(time.RFC3339) != tt.wantStart || end.Format(time.RFC3339) != tt.wantEnd {
+				t.Errorf("got %s %s..%s, want %s %s..%s", month, start.Format(time.RFC3339), end.Format(time.RFC3339),
+					tt.wantMonth, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/lypolix/FaaS-billing/internal/models"
+)
+
+func TestRemainingFreeTier(t *testing.T) {
+	plan := models.PricingPlan{
+		FreeTierInvocations: 1000,
+		FreeTierGBHours:     10,
+		FreeTierEgressGB:    5,
+		FreeTierColdStarts:  100,
+		FreeTierVCPUHours:   2,
+	}
+	tests := []struct {
+		name string
+		used UsageTotals
+		want models.PricingPlan
+	}{
+		{name: "nothing used", want: plan},
+		{
+			name: "partly used",
+			used: UsageTotals{TotalInvocations: 400, TotalGBHours: 2.5, TotalEgressGB: 1, TotalColdStarts: 10, TotalVCPUHours: 0.5},
+			want: models.PricingPlan{FreeTierInvocations: 600, FreeTierGBHours: 7.5, FreeTierEgressGB: 4, FreeTierColdStarts: 90, FreeTierVCPUHours: 1.5},
+		},
+		{
+			name: "overused does not go negative",
+			used: UsageTotals{TotalInvocations: 5000, TotalGBHours: 20, TotalEgressGB: 6, TotalColdStarts: 101, TotalVCPUHours: 3},
+			want: models.PricingPlan{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := remainingFreeTier(plan, tt.used)
+			if got.FreeTierInvocations != tt.want.FreeTierInvocations ||
+				!approx(got.FreeTierGBHours, tt.want.FreeTierGBHours) ||
+				!approx(got.FreeTierEgressGB, tt.want.FreeTierEgressGB) ||
+				got.FreeTierColdStarts != tt.want.FreeTierColdStarts ||
+				!approx(got.FreeTierVCPUHours, tt.want.FreeTierVCPUHours) {
+				t.Errorf("got %d/%v/%v/%d/%v, want %d/%v/%v/%d/%v",
+					got.FreeTierInvocations, got.FreeTierGBHours, got.FreeTierEgressGB, got.FreeTierColdStarts, got.FreeTierVCPUHours,
+					tt.want.FreeTierInvocations, tt.want.FreeTierGBHours, tt.want.FreeTierEgressGB, tt.want.FreeTierColdStarts, tt.want.FreeTierVCPUHours)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lypolix/FaaS-billing/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrBillNotFound          = errors.New("bill not found")
+	ErrInvalidBillTransition = errors.New("invalid bill status transition")
+	ErrBillOverlap           = errors.New("bill period overlaps an existing bill")
+	ErrInvalidOverlapPolicy  = errors.New("unsupported overlap policy")
+	ErrInvalidInvoicePrefix  = errors.New("invoice prefix must be 2-12 latin letters or digits")
+	ErrInvoicePrefixTaken    = errors.New("invoice prefix is already used by another tenant")
+)
+
+// Разрешённые переходы статусов счёта
+var billTransitions = map[string][]string{
+	models.BillStatusDraft: {models.BillStatusFinal, models.BillStatusVoid},
+	models.BillStatusFinal: {models.BillStatusPaid, models.BillStatusVoid},
+}
+
+func canTransition(from, to string) bool {
+	for _, s := range billTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+type BillFilter struct {
+	TenantID string
+	Status   string
+}
+
+var billListSpec = ListSpec{
+	Sorts: map[string]SortField{
+		"period_start": {Column: "period_start", Time: true},
+		"created_at":   {Column: "created_at", Time: true},
+	},
+	DefaultSort: "-period_start",
+}
+
+func (s *BillingService) ListBills(f BillFilter, page PageRequest) (*Page[models.Bill], error) {
+	q := s.db.Model(&models.Bill{})
+	if f.TenantID != "" {
+		q = q.Where("tenant_id = ?", f.TenantID)
+	}
+	if f.Status != "" {
+		q = q.Where("status = ?", f.Status)
+	}
+	return Paginate(q, page, billListSpec, func(b models.Bill, sort string) (interface{}, interface{}) {
+		if sort == "created_at" {
+			return b.CreatedAt, b.ID
+		}
+		return b.PeriodStart, b.ID
+	})
+}
+
+func (s *BillingService) GetBill(id uuid.UUID) (*models.Bill, error) {
+	return getBill(s.db, id)
+}
+
+func getBill(db *gorm.DB, id uuid.UUID) (*models.Bill, error) {
+	var bill models.Bill
+	if err := db.First(&bill, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrBillNotFound
+		}
+		return nil, err
+	}
+	return &bill, nil
+}
+
+// FinalizeBill пересчитывает черновик по актуальным агрегатам, замораживает
+// тариф и итоги использования и присваивает счёту номер. После этого счёт
+// доступен только для перевода в paid или void.
+func (s *BillingService) FinalizeBill(id uuid.UUID) (*models.Bill, error) {
+	var out *models.Bill
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		bill, err := getBill(tx.Clauses(clause.Locking{Strength: "UPDATE"}), id)
+		if err != nil {
+			return err
+		}
+		if !canTransition(bill.Status, models.BillStatusFinal) {
+			return fmt.Errorf("%w: %s -> %s", ErrInvalidBillTransition, bill.Status, models.BillStatusFinal)
+		}
+
+		// списания кредитов по арендатору выполняются строго по очереди
+		if err := lockLedger(tx, bill.TenantID); err != nil {
+			return err
+		}
+
+		calc, err := (&BillingService{db: tx}).calculate(bill.TenantID.String(), bill.PeriodStart, bill.PeriodEnd)
+		if err != nil {
+			return err
+		}
+
+		number, err := nextInvoiceNumber(tx, bill.TenantID)
+		if err != nil {
+			return err
+		}
+
+		planSnapshot, err := toJSONB(calc.Plan)
+		if err != nil {
+			return err
+		}
+		if len(calc.Segments) > 1 {
+			var segs []map[string]interface{}
+			for _, seg := range calc.Segments {
+				segs = append(segs, map[string]interface{}{
+					"period_start":    seg.Start,
+					"period_end":      seg.End,
+					"pricing_plan_id": seg.Plan.ID,
+					"version":         seg.Plan.Version,
+				})
+			}
+			planSnapshot["segments"] = segs
+		}
+		usageSnapshot, err := toJSONB(calc.Totals)
+		if err != nil {
+			return err
+		}
+		usageSnapshot["aggregates_count"] = calc.Aggregates
+
+		now := time.Now()
+		bill.InvoiceNumber = &number
+		bill.Status = models.BillStatusFinal
+		applyResult(bill, calc.Result)
+		bill.PricingPlanID = &calc.Plan.ID
+		bill.PlanSnapshot = planSnapshot
+		bill.UsageSnapshot = usageSnapshot
+		bill.FinalizedAt = &now
+
+		if err := tx.Omit("Tenant").Save(bill).Error; err != nil {
+			return err
+		}
+		if err := recordCreditApplications(tx, bill, calc.Result.Credits); err != nil {
+			return err
+		}
+		if err := markAdjustmentsApplied(tx, bill, calc.Result.AdjustmentIDs); err != nil {
+			return err
+		}
+		out = bill
+		return nil
+	})
+	return out, err
+}
+
+// VoidBill аннулирует черновик или финальный счёт. Номер счёта сохраняется,
+// чтобы нумерация оставалась непрерывной; списанные счётом кредиты
+// возвращаются на баланс сторнирующими записями.
+func (s *BillingService) VoidBill(id uuid.UUID, reason string) (*models.Bill, error) {
+	now := time.Now()
+	return s.transition(id, models.BillStatusVoid, map[string]interface{}{
+		"voided_at":   now,
+		"void_reason": reason,
+	}, func(tx *gorm.DB, bill *models.Bill) error {
+		if err := reverseCreditApplications(tx, bill); err != nil {
+			return err
+		}
+		return reopenAdjustments(tx, bill)
+	})
+}
+
+func (s *BillingService) MarkBillPaid(id uuid.UUID, paidAt *time.Time, reference string) (*models.Bill, error) {
+	if paidAt == nil {
+		now := time.Now()
+		paidAt = &now
+	}
+	return s.transition(id, models.BillStatusPaid, map[string]interface{}{
+		"paid_at":           *paidAt,
+		"payment_reference": reference,
+	}, nil)
+}
+
+// transition меняет только статус и служебные поля; суммы и строки
+// счёта остаются нетронутыми. after выполняется в той же транзакции.
+func (s *BillingService) transition(id uuid.UUID, to string, fields map[string]interface{}, after func(tx *gorm.DB, bill *models.Bill) error) (*models.Bill, error) {
+	var out *models.Bill
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		bill, err := getBill(tx.Clauses(clause.Locking{Strength: "UPDATE"}), id)
+		if err != nil {
+			return err
+		}
+		if !canTransition(bill.Status, to) {
+			return fmt.Errorf("%w: %s -> %s", ErrInvalidBillTransition, bill.Status, to)
+		}
+
+		fields["status"] = to
+		res := tx.Model(&models.Bill{}).
+			Where("id = ? AND status = ?", bill.ID, bill.Status).
+			Updates(fields)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return fmt.Errorf("%w: bill was modified concurrently", ErrInvalidBillTransition)
+		}
+		if after != nil {
+			if err := after(tx, bill); err != nil {
+				return err
+			}
+		}
+
+		out, err = getBill(tx, id)
+		return err
+	})
+	return out, err
+}
+
+// nextInvoiceNumber атомарно увеличивает счётчик арендатора и возвращает
+// номер вида INV-<префикс арендатора>-000042. Префикс уникален (индекс на
+// tenants.invoice_prefix), поэтому уникален и номер.
+func nextInvoiceNumber(tx *gorm.DB, tenantID uuid.UUID) (string, error) {
+	var seq int64
+	err := tx.Raw(`
+		INSERT INTO invoice_sequences (tenant_id, last_number)
+		VALUES (?, 1)
+		ON CONFLICT (tenant_id)
+		DO UPDATE SET last_number = invoice_sequences.last_number + 1
+		RETURNING last_number
+	`, tenantID).Scan(&seq).Error
+	if err != nil {
+		return "", fmt.Errorf("failed to allocate invoice number: %w", err)
+	}
+	// строка счётчика заблокирована до конца транзакции, поэтому префикс
+	// арендатору назначается один раз
+	prefix, err := ensureInvoicePrefix(tx, tenantID)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("INV-%s-%06d", prefix, seq), nil
+}
+
+// NormalizeInvoicePrefix приводит префикс номеров счетов к верхнему
+// регистру и проверяет формат
+func NormalizeInvoicePrefix(s string) (string, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if len(s) < 2 || len(s) > 12 {
+		return "", ErrInvalidInvoicePrefix
+	}
+	for _, r := range s {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return "", ErrInvalidInvoicePrefix
+		}
+	}
+	return s, nil
+}
+
+// ensureInvoicePrefix возвращает префикс арендатора, а если он не задан -
+// назначает первые свободные 6, 8, ... символов его ID
+func ensureInvoicePrefix(tx *gorm.DB, tenantID uuid.UUID) (string, error) {
+	var tenant models.Tenant
+	if err := tx.Select("id", "invoice_prefix").First(&tenant, "id = ?", tenantID).Error; err != nil {
+		return "", fmt.Errorf("tenant %s: %w", tenantID, err)
+	}
+	if tenant.InvoicePrefix != nil && *tenant.InvoicePrefix != "" {
+		return *tenant.InvoicePrefix, nil
+	}
+	hex := strings.ToUpper(strings.ReplaceAll(tenantID.String(), "-", ""))
+	for n := 6; n <= 12; n += 2 {
+		var taken int64
+		if err := tx.Model(&models.Tenant{}).Where("invoice_prefix = ?", hex[:n]).Count(&taken).Error; err != nil {
+			return "", err
+		}
+		if taken > 0 {
+			continue
+		}
+		if err := tx.Model(&models.Tenant{}).Where("id = ?", tenantID).
+			Update("invoice_prefix", hex[:n]).Error; err != nil {
+			return "", err
+		}
+		return hex[:n], nil
+	}
+	return "", fmt.Errorf("tenant %s: %w", tenantID, ErrInvoicePrefixTaken)
+}
+
+func toJSONB(v interface{}) (models.JSONB, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	out := make(models.JSONB)
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
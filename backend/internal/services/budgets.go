@@ -0,0 +1,383 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lypolix/FaaS-billing/internal/models"
+	"github.com/lypolix/FaaS-billing/internal/services/forecast"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Пороги по умолчанию: 50/80/100% фактических расходов и 100% прогноза
+const (
+	DefaultActualThresholds   = "50,80,100"
+	DefaultForecastThresholds = "100"
+)
+
+var ErrInvalidBudget = errors.New("invalid budget")
+
+// BudgetService проверяет месячные бюджеты арендаторов: фактические
+// расходы с начала месяца и прогноз на конец месяца сравниваются с
+// порогами, сработавшие пороги отправляются в каналы уведомлений.
+type BudgetService struct {
+	db        *gorm.DB
+	billing   *BillingService
+	notifiers map[string]Notifier
+}
+
+func NewBudgetService(db *gorm.DB, notifiers ...Notifier) *BudgetService {
+	s := &BudgetService{
+		db:        db,
+		billing:   NewBillingService(db),
+		notifiers: map[string]Notifier{},
+	}
+	for _, n := range notifiers {
+		s.notifiers[n.Name()] = n
+	}
+	return s
+}
+
+// ValidateBudget проверяет бюджет и подставляет значения по умолчанию
+func ValidateBudget(b *models.Budget, tenant models.Tenant) error {
+	if b.Amount <= 0 {
+		return fmt.Errorf("%w: amount must be positive", ErrInvalidBudget)
+	}
+	if b.Currency == "" {
+		b.Currency = tenant.Currency
+	}
+	if normalizeCurrency(b.Currency) != normalizeCurrency(tenant.Currency) {
+		return fmt.Errorf("%w: currency must match tenant currency %s", ErrInvalidBudget, normalizeCurrency(tenant.Currency))
+	}
+	b.Currency = normalizeCurrency(b.Currency)
+
+	if b.ActualThresholds == "" && b.ForecastThresholds == "" {
+		b.ActualThresholds, b.ForecastThresholds = DefaultActualThresholds, DefaultForecastThresholds
+	}
+	for _, v := range []*string{&b.ActualThresholds, &b.ForecastThresholds} {
+		th, err := parseThresholds(*v)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidBudget, err)
+		}
+		*v = joinThresholds(th)
+	}
+
+	if b.Channels == "" {
+		b.Channels = ChannelLog
+	}
+	for ch := range splitDimensions(b.Channels) {
+		switch ch {
+		case ChannelLog, ChannelWebhook, ChannelEmail:
+		default:
+			return fmt.Errorf("%w: unknown channel %q, use log, webhook or email", ErrInvalidBudget, ch)
+		}
+	}
+	return nil
+}
+
+// parseThresholds разбирает проценты через запятую, результат отсортирован
+func parseThresholds(s string) ([]int, error) {
+	var out []int
+	seen := map[int]bool{}
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(p), "%")); p == "" {
+			continue
+		}
+		v, err := strconv.Atoi(p)
+		if err != nil || v <= 0 || v > 1000 {
+			return nil, fmt.Errorf("threshold %q must be a percent in 1..1000", p)
+		}
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	sort.Ints(out)
+	return out, nil
+}
+
+func joinThresholds(th []int) string {
+	parts := make([]string, len(th))
+	for i, v := range th {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, ",")
+}
+
+// BudgetStatus - состояние бюджета на момент проверки
+type BudgetStatus struct {
+	Budget      models.Budget        `json:"budget"`
+	PeriodStart time.Time            `json:"period_start"`
+	PeriodEnd   time.Time            `json:"period_end"`
+	Actual      float64              `json:"actual"`
+	Forecast    *float64             `json:"forecast"` // nil, если прогноз недоступен
+	Currency    string               `json:"currency"`
+	Fired       []models.BudgetAlert `json:"fired"` // пороги, сработавшие при этой проверке
+}
+
+// Evaluate проверяет активные бюджеты (всех арендаторов или одного).
+// Ошибка по одному бюджету не прерывает проверку остальных.
+func (s *BudgetService) Evaluate(now time.Time, tenantID *uuid.UUID) ([]BudgetStatus, error) {
+	var budgets []models.Budget
+	q := s.db.Where("active = true").Order("tenant_id, created_at")
+	if tenantID != nil {
+		q = q.Where("tenant_id = ?", *tenantID)
+	}
+	if err := q.Find(&budgets).Error; err != nil {
+		return nil, fmt.Errorf("failed to load budgets: %w", err)
+	}
+
+	tenants := map[uuid.UUID]models.Tenant{}
+	var out []BudgetStatus
+	for _, b := range budgets {
+		tenant, ok := tenants[b.TenantID]
+		if !ok {
+			if err := s.db.First(&tenant, "id = ?", b.TenantID).Error; err != nil {
+				log.Printf("budgets: tenant %s of budget %s: %v", b.TenantID, b.ID, err)
+				continue
+			}
+			tenants[b.TenantID] = tenant
+		}
+		st, err := s.evaluateBudget(b, tenant, now)
+		if err != nil {
+			log.Printf("budgets: budget %s: %v", b.ID, err)
+			continue
+		}
+		out = append(out, *st)
+	}
+	return out, nil
+}
+
+// Status считает расходы и прогноз по бюджету без отправки уведомлений
+func (s *BudgetService) Status(b models.Budget, now time.Time) (*BudgetStatus, error) {
+	var tenant models.Tenant
+	if err := s.db.First(&tenant, "id = ?", b.TenantID).Error; err != nil {
+		return nil, fmt.Errorf("tenant not found: %w", err)
+	}
+	return s.status(b, tenant, now)
+}
+
+func (s *BudgetService) status(b models.Budget, tenant models.Tenant, now time.Time) (*BudgetStatus, error) {
+	start, end := currentMonth(tenant, now)
+	actual, currency, err := s.spend(tenant, b.ServiceID, start, now)
+	if err != nil {
+		return nil, err
+	}
+	st := &BudgetStatus{
+		Budget:      b,
+		PeriodStart: start,
+		PeriodEnd:   end,
+		Actual:      actual,
+		Currency:    currency,
+	}
+	if f, err := s.forecast(tenant, b.ServiceID, currency, now, end); err != nil {
+		log.Printf("budgets: forecast for budget %s unavailable: %v", b.ID, err)
+	} else {
+		f = roundMoney(actual + f)
+		st.Forecast = &f
+	}
+	return st, nil
+}
+
+func (s *BudgetService) evaluateBudget(b models.Budget, tenant models.Tenant, now time.Time) (*BudgetStatus, error) {
+	st, err := s.status(b, tenant, now)
+	if err != nil {
+		return nil, err
+	}
+
+	check := func(kind, thresholds string, amount float64) {
+		th, _ := parseThresholds(thresholds)
+		for _, t := range th {
+			if amount < b.Amount*float64(t)/100 {
+				break
+			}
+			alert, err := s.fire(b, tenant, st, kind, t, amount)
+			if err != nil {
+				log.Printf("budgets: budget %s %s %d%%: %v", b.ID, kind, t, err)
+				continue
+			}
+			if alert != nil {
+				st.Fired = append(st.Fired, *alert)
+			}
+		}
+	}
+	check(models.BudgetAlertActual, b.ActualThresholds, st.Actual)
+	if st.Forecast != nil {
+		check(models.BudgetAlertForecast, b.ForecastThresholds, *st.Forecast)
+	}
+	return st, nil
+}
+
+// fire фиксирует срабатывание порога и рассылает уведомление. Если порог
+// уже срабатывал в этом периоде, возвращает nil. Если не удалось доставить
+// ни в один канал, отметка снимается, чтобы повторить при следующей проверке.
+func (s *BudgetService) fire(b models.Budget, tenant models.Tenant, st *BudgetStatus, kind string, threshold int, amount float64) (*models.BudgetAlert, error) {
+	alert := models.BudgetAlert{
+		ID:          uuid.New(),
+		BudgetID:    b.ID,
+		PeriodStart: st.PeriodStart,
+		Kind:        kind,
+		Threshold:   threshold,
+		Amount:      amount,
+		Currency:    st.Currency,
+		FiredAt:     time.Now(),
+	}
+	res := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&alert)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	n := BudgetNotification{
+		BudgetID:    b.ID,
+		BudgetName:  b.Name,
+		TenantID:    tenant.ID,
+		TenantName:  tenant.Name,
+		ServiceID:   b.ServiceID,
+		Kind:        kind,
+		Threshold:   threshold,
+		Amount:      amount,
+		Budget:      b.Amount,
+		Currency:    st.Currency,
+		PeriodStart: st.PeriodStart,
+		PeriodEnd:   st.PeriodEnd,
+		Email:       tenant.BillingEmail,
+		WebhookURL:  b.WebhookURL,
+	}
+	var delivered []string
+	var errs []string
+	for ch := range splitDimensions(b.Channels) {
+		notifier, ok := s.notifiers[ch]
+		if !ok {
+			errs = append(errs, ch+": "+ErrNotifierNotConfigured.Error())
+			continue
+		}
+		if err := notifier.Notify(n); err != nil {
+			errs = append(errs, ch+": "+err.Error())
+			continue
+		}
+		delivered = append(delivered, ch)
+	}
+
+	if len(delivered) == 0 {
+		s.db.Delete(&alert)
+		return nil, fmt.Errorf("notification not delivered: %s", strings.Join(errs, "; "))
+	}
+	if len(errs) > 0 {
+		log.Printf("budgets: budget %s: some channels failed: %s", b.ID, strings.Join(errs, "; "))
+	}
+	sort.Strings(delivered)
+	alert.Channels = joinDimensions(delivered)
+	if err := s.db.Model(&alert).Update("channels", alert.Channels).Error; err != nil {
+		return nil, err
+	}
+	return &alert, nil
+}
+
+// spend - расходы по использованию за период со скидками контракта, без
+// кредитов, доплаты до минимального платежа и налога. Для бюджета на
+// сервис каждая строка делится пропорционально доле сервиса в её объёме.
+func (s *BudgetService) spend(tenant models.Tenant, serviceID *uuid.UUID, start, end time.Time) (float64, string, error) {
+	calc, err := s.billing.calculate(tenant.ID.String(), start, end)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var shares map[string]float64
+	if serviceID != nil {
+		var aggs []models.UsageAggregate
+		if err := s.db.Scopes(models.CanonicalAggregates).Where(
+			"tenant_id = ? AND service_id = ? AND window_start >= ? AND window_end <= ?",
+			tenant.ID, *serviceID, start, end,
+		).Find(&aggs).Error; err != nil {
+			return 0, "", fmt.Errorf("failed to get usage aggregates: %w", err)
+		}
+		shares = usageShares(s.billing.calculateTotals(aggs), calc.Totals)
+	}
+
+	var total float64
+	for _, it := range calc.Result.LineItems {
+		code := it.Code
+		switch it.Code {
+		case models.LineItemCredits, models.LineItemCommitTrueUp, models.LineItemLateUsage:
+			continue
+		case models.LineItemDiscount:
+			code = it.AppliesTo
+		}
+		if shares != nil {
+			total += it.TotalCost * shares[code]
+		} else {
+			total += it.TotalCost
+		}
+	}
+	return roundMoney(total), calc.Result.Currency, nil
+}
+
+// usageShares - доля part в all по каждому коду строки счёта
+func usageShares(part, all UsageTotals) map[string]float64 {
+	share := func(p, a float64) float64 {
+		if a <= 0 {
+			return 0
+		}
+		return p / a
+	}
+	return map[string]float64{
+		models.LineItemInvocations:    share(float64(part.TotalInvocations), float64(all.TotalInvocations)),
+		models.LineItemComputeGBHours: share(part.TotalGBHours, all.TotalGBHours),
+		models.LineItemEgressGB:       share(part.TotalEgressGB, all.TotalEgressGB),
+		models.LineItemVCPUHours:      share(part.TotalVCPUHours, all.TotalVCPUHours),
+		models.LineItemColdStarts:     share(float64(part.TotalColdStarts), float64(all.TotalColdStarts)),
+		models.LineItemColdStartInit:  share(part.TotalColdStartGBSeconds, all.TotalColdStartGBSeconds),
+	}
+}
+
+// forecast - прогноз расходов с now до конца периода: дневной прогноз
+// forecast.ForecastCost, умноженный на оставшиеся дни, в валюте бюджета
+func (s *BudgetService) forecast(tenant models.Tenant, serviceID *uuid.UUID, currency string, now, end time.Time) (float64, error) {
+	days := end.Sub(now).Hours() / 24
+	if days <= 0 {
+		return 0, nil
+	}
+	req := forecast.ForecastRequest{TenantID: tenant.ID.String(), Period: "1d"}
+	if serviceID != nil {
+		sid := serviceID.String()
+		req.ServiceID = &sid
+	}
+	resp, err := forecast.ForecastCost(req)
+	if err != nil {
+		return 0, err
+	}
+
+	// прогноз считается в валюте плана арендатора
+	daily := resp.ForecastedCost
+	if tenant.PricingPlanID != nil {
+		var plan models.PricingPlan
+		if err := s.db.Select("currency").First(&plan, "id = ?", *tenant.PricingPlanID).Error; err == nil {
+			rate, _, err := NewCurrencyService(s.db).Rate(plan.Currency, currency, now)
+			if err != nil {
+				return 0, err
+			}
+			daily *= rate
+		}
+	}
+	return daily * days, nil
+}
+
+// currentMonth - границы текущего календарного месяца в таймзоне арендатора
+func currentMonth(t models.Tenant, now time.Time) (time.Time, time.Time) {
+	loc, err := time.LoadLocation(t.Timezone)
+	if err != nil || t.Timezone == "" {
+		loc = time.UTC
+	}
+	start, end, _ := tenantMonth(t, now.In(loc).Format("2006-01"), now)
+	return start, end
+}
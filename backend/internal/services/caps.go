@@ -0,0 +1,162 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lypolix/FaaS-billing/internal/models"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidCap = errors.New("invalid spending cap")
+
+// Причины снятия приостановки
+const (
+	ResumeBelowLimit  = "below_limit"  // лимит повышен или расходы ниже лимита
+	ResumeCapDisabled = "cap_disabled" // лимит отключён
+	ResumePeriodEnded = "period_ended" // начался новый месяц
+)
+
+// CapService применяет жёсткие лимиты расходов: при превышении лимита за
+// месяц арендатор или сервис приостанавливается, data plane получает
+// список приостановок через ActiveSuspensions и отклоняет вызовы.
+type CapService struct {
+	db      *gorm.DB
+	budgets *BudgetService
+}
+
+func NewCapService(db *gorm.DB) *CapService {
+	return &CapService{db: db, budgets: NewBudgetService(db)}
+}
+
+func ValidateCap(c *models.SpendingCap, tenant models.Tenant) error {
+	if c.Limit <= 0 {
+		return fmt.Errorf("%w: limit must be positive", ErrInvalidCap)
+	}
+	if c.Currency == "" {
+		c.Currency = tenant.Currency
+	}
+	if normalizeCurrency(c.Currency) != normalizeCurrency(tenant.Currency) {
+		return fmt.Errorf("%w: currency must match tenant currency %s", ErrInvalidCap, normalizeCurrency(tenant.Currency))
+	}
+	c.Currency = normalizeCurrency(c.Currency)
+	return nil
+}
+
+// CapStatus - результат проверки лимита
+type CapStatus struct {
+	Cap        models.SpendingCap `json:"cap"`
+	Spend      float64            `json:"spend"`
+	Currency   string             `json:"currency"`
+	Suspended  bool               `json:"suspended"`
+	Suspension *models.Suspension `json:"suspension,omitempty"`
+}
+
+// Evaluate проверяет лимиты (всех арендаторов или одного): приостанавливает
+// при превышении и снимает приостановку, если лимит повышен, отключён или
+// начался новый месяц
+func (s *CapService) Evaluate(now time.Time, tenantID *uuid.UUID) ([]CapStatus, error) {
+	var caps []models.SpendingCap
+	q := s.db.Order("tenant_id, created_at")
+	if tenantID != nil {
+		q = q.Where("tenant_id = ?", *tenantID)
+	}
+	if err := q.Find(&caps).Error; err != nil {
+		return nil, fmt.Errorf("failed to load spending caps: %w", err)
+	}
+
+	var out []CapStatus
+	for _, c := range caps {
+		st, err := s.evaluateCap(c, now)
+		if err != nil {
+			log.Printf("caps: cap %s: %v", c.ID, err)
+			continue
+		}
+		out = append(out, *st)
+	}
+	return out, nil
+}
+
+func (s *CapService) evaluateCap(c models.SpendingCap, now time.Time) (*CapStatus, error) {
+	if !c.Active {
+		if err := s.resume(c.ID, nil, ResumeCapDisabled, now); err != nil {
+			return nil, err
+		}
+		return &CapStatus{Cap: c}, nil
+	}
+
+	var tenant models.Tenant
+	if err := s.db.First(&tenant, "id = ?", c.TenantID).Error; err != nil {
+		return nil, fmt.Errorf("tenant not found: %w", err)
+	}
+	start, end := currentMonth(tenant, now)
+
+	// приостановки прошлых месяцев снимаются сами
+	if err := s.resume(c.ID, &start, ResumePeriodEnded, now); err != nil {
+		return nil, err
+	}
+
+	spend, currency, err := s.budgets.spend(tenant, c.ServiceID, start, now)
+	if err != nil {
+		return nil, err
+	}
+	st := &CapStatus{Cap: c, Spend: spend, Currency: currency}
+
+	if spend < c.Limit {
+		return st, s.resume(c.ID, nil, ResumeBelowLimit, now)
+	}
+
+	var sus models.Suspension
+	err = s.db.Where("cap_id = ? AND period_start = ? AND resumed_at IS NULL", c.ID, start).First(&sus).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		sus = models.Suspension{
+			ID:          uuid.New(),
+			CapID:       c.ID,
+			TenantID:    c.TenantID,
+			ServiceID:   c.ServiceID,
+			PeriodStart: start,
+			PeriodEnd:   end,
+			Spend:       spend,
+			Limit:       c.Limit,
+			Currency:    currency,
+			SuspendedAt: now,
+		}
+		if c.ServiceID != nil {
+			var svc models.Service
+			if err := s.db.Select("name").First(&svc, "id = ?", *c.ServiceID).Error; err == nil {
+				sus.ServiceName = svc.Name
+			}
+		}
+		if err := s.db.Create(&sus).Error; err != nil {
+			return nil, err
+		}
+		log.Printf("caps: tenant %s suspended: spend %.2f >= limit %.2f %s", c.TenantID, spend, c.Limit, currency)
+	}
+	st.Suspended = true
+	st.Suspension = &sus
+	return st, nil
+}
+
+// resume снимает открытые приостановки лимита; если periodStart задан -
+// только относящиеся к другим месяцам
+func (s *CapService) resume(capID uuid.UUID, periodStart *time.Time, reason string, now time.Time) error {
+	q := s.db.Model(&models.Suspension{}).Where("cap_id = ? AND resumed_at IS NULL", capID)
+	if periodStart != nil {
+		q = q.Where("period_start <> ?", *periodStart)
+	}
+	return q.Updates(map[string]interface{}{"resumed_at": now, "reason": reason}).Error
+}
+
+// ActiveSuspensions - действующие приостановки для data plane
+func (s *CapService) ActiveSuspensions(now time.Time) ([]models.Suspension, error) {
+	var out []models.Suspension
+	err := s.db.Where("resumed_at IS NULL AND period_end > ?", now).
+		Order("suspended_at").Find(&out).Error
+	return out, err
+}
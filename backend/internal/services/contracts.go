@@ -0,0 +1,140 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lypolix/FaaS-billing/internal/i18n"
+	"github.com/lypolix/FaaS-billing/internal/models"
+	"gorm.io/gorm"
+)
+
+// Статьи, на которые может распространяться скидка
+var discountableItems = map[string]bool{
+	models.LineItemInvocations:    true,
+	models.LineItemComputeGBHours: true,
+	models.LineItemEgressGB:       true,
+	models.LineItemVCPUHours:      true,
+	models.LineItemColdStarts:     true,
+	models.LineItemColdStartInit:  true,
+}
+
+// ValidateContract проверяет условия контракта и подставляет валюту арендатора
+func ValidateContract(c *models.Contract, tenant models.Tenant) error {
+	if c.DiscountPercent < 0 || c.DiscountPercent > 100 {
+		return fmt.Errorf("discount_percent must be between 0 and 100")
+	}
+	for code, v := range c.DimensionDiscounts {
+		if !discountableItems[code] {
+			return fmt.Errorf("unknown dimension %q in dimension_discounts", code)
+		}
+		pct, ok := v.(float64)
+		if !ok || pct < 0 || pct > 100 {
+			return fmt.Errorf("discount for %s must be a number between 0 and 100", code)
+		}
+	}
+	if c.MinimumCommit < 0 {
+		return fmt.Errorf("minimum_commit must not be negative")
+	}
+	if c.ValidFrom.IsZero() {
+		return fmt.Errorf("valid_from is required")
+	}
+	if c.ValidTo != nil && !c.ValidTo.After(c.ValidFrom) {
+		return fmt.Errorf("valid_to must be after valid_from")
+	}
+
+	currency := normalizeCurrency(tenant.Currency)
+	if c.Currency == "" {
+		c.Currency = currency
+	}
+	c.Currency = strings.ToUpper(c.Currency)
+	if c.Currency != currency {
+		return fmt.Errorf("contract currency %s must match tenant currency %s", c.Currency, currency)
+	}
+	return nil
+}
+
+// activeContract возвращает действующий в периоде контракт арендатора;
+// при нескольких пересекающихся берётся начавшийся позже всех
+func activeContract(db *gorm.DB, tenantID uuid.UUID, start, end time.Time) (*models.Contract, error) {
+	var c models.Contract
+	err := db.Where("tenant_id = ? AND active = ? AND valid_from < ?", tenantID, true, end).
+		Where("valid_to IS NULL OR valid_to > ?", start).
+		Order("valid_from DESC").
+		First(&c).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load contract: %w", err)
+	}
+	return &c, nil
+}
+
+// applyContract добавляет строки скидок по каждой статье и доплату до
+// минимального платежа. Минимальный платёж пропорционален доле периода,
+// покрытой контрактом.
+func applyContract(result *models.BillingResult, c *models.Contract, start, end time.Time) {
+	var net float64
+	var discounts []models.BillingLineItem
+	for _, it := range result.LineItems {
+		net += it.TotalCost
+		if it.TotalCost <= 0 || !discountableItems[it.Code] {
+			continue
+		}
+		pct := c.DiscountPercent
+		if v, ok := c.DimensionDiscounts[it.Code].(float64); ok {
+			pct = v
+		}
+		amount := roundMoney(it.TotalCost * pct / 100)
+		if amount <= 0 {
+			continue
+		}
+		discounts = append(discounts, models.BillingLineItem{
+			Code:           models.LineItemDiscount,
+			AppliesTo:      it.Code,
+			Unit:           "percent",
+			Description:    i18n.LineItemLabel(i18n.DefaultLocale, models.LineItemDiscount, it.Code, ""),
+			Quantity:       pct,
+			UnitPrice:      -it.TotalCost / 100,
+			BillableAmount: pct,
+			TotalCost:      -amount,
+			Currency:       result.Currency,
+		})
+		net -= amount
+	}
+	result.LineItems = append(result.LineItems, discounts...)
+	result.ContractID = &c.ID
+
+	if c.MinimumCommit <= 0 {
+		return
+	}
+	from, to := start, end
+	if c.ValidFrom.After(from) {
+		from = c.ValidFrom
+	}
+	if c.ValidTo != nil && c.ValidTo.Before(to) {
+		to = *c.ValidTo
+	}
+	commit := c.MinimumCommit
+	if period := end.Sub(start); period > 0 && to.Sub(from) < period {
+		commit = commit * float64(to.Sub(from)) / float64(period)
+	}
+	commit = roundMoney(commit)
+
+	if shortfall := roundMoney(commit - net); shortfall > 0 {
+		result.LineItems = append(result.LineItems, models.BillingLineItem{
+			Code:           models.LineItemCommitTrueUp,
+			Unit:           result.Currency,
+			Description:    lineItemDescription(models.LineItemCommitTrueUp),
+			Quantity:       shortfall,
+			UnitPrice:      1,
+			BillableAmount: shortfall,
+			TotalCost:      shortfall,
+			Currency:       result.Currency,
+		})
+	}
+}
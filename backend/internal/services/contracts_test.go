@@ -0,0 +1,87 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lypolix/FaaS-billing/internal/models"
+)
+
+func TestApplyContract(t *testing.T) {
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	mid := start.Add(end.Sub(start) / 2)
+	usage := func() []models.BillingLineItem {
+		return []models.BillingLineItem{
+			{Code: models.LineItemInvocations, TotalCost: 100},
+			{Code: models.LineItemComputeGBHours, TotalCost: 50},
+			{Code: models.LineItemLateUsage, TotalCost: 20},
+		}
+	}
+	tests := []struct {
+		name      string
+		contract  models.Contract
+		discounts map[string]float64 // AppliesTo -> TotalCost
+		trueUp    float64
+	}{
+		{
+			name:      "flat discount skips non-usage lines",
+			contract:  models.Contract{DiscountPercent: 10, ValidFrom: start},
+			discounts: map[string]float64{models.LineItemInvocations: -10, models.LineItemComputeGBHours: -5},
+		},
+		{
+			name: "dimension discount overrides flat",
+			contract: models.Contract{DiscountPercent: 10, ValidFrom: start,
+				DimensionDiscounts: models.JSONB{models.LineItemComputeGBHours: 50.0}},
+			discounts: map[string]float64{models.LineItemInvocations: -10, models.LineItemComputeGBHours: -25},
+		},
+		{
+			name:      "true-up to minimum after discounts",
+			contract:  models.Contract{DiscountPercent: 10, MinimumCommit: 200, ValidFrom: start},
+			discounts: map[string]float64{models.LineItemInvocations: -10, models.LineItemComputeGBHours: -5},
+			trueUp:    45, // 200 - (170 - 15)
+		},
+		{
+			name:     "minimum prorated to covered part of period",
+			contract: models.Contract{MinimumCommit: 400, ValidFrom: mid},
+			trueUp:   30, // 400 / 2 - 170
+		},
+		{
+			name:     "no true-up above minimum",
+			contract: models.Contract{MinimumCommit: 100, ValidFrom: start},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &models.BillingResult{LineItems: usage(), Currency: "RUB"}
+			applyContract(result, &tt.contract, start, end)
+
+			discounts := map[string]float64{}
+			var trueUp float64
+			for _, it := range result.LineItems[3:] {
+				switch it.Code {
+				case models.LineItemDiscount:
+					discounts[it.AppliesTo] = it.TotalCost
+				case models.LineItemCommitTrueUp:
+					trueUp = it.TotalCost
+				default:
+					t.Errorf("unexpected line %s", it.Code)
+				}
+			}
+			if len(discounts) != len(tt.discounts) {
+				t.Errorf("discounts = %v, want %v", discounts, tt.discounts)
+			}
+			for code, want := range tt.discounts {
+				if !approx(discounts[code], want) {
+					t.Errorf("discount %s = %v, want %v", code, discounts[code], want)
+				}
+			}
+			if !approx(trueUp, tt.trueUp) {
+				t.Errorf("true-up = %v, want %v", trueUp, tt.trueUp)
+			}
+			if result.ContractID == nil {
+				t.Error("contract id is not set")
+			}
+		})
+	}
+}
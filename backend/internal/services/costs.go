@@ -0,0 +1,287 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lypolix/FaaS-billing/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidCostQuery = errors.New("invalid cost query")
+	ErrTenantNotFound   = errors.New("tenant not found")
+)
+
+// Шаг временного ряда стоимости
+const (
+	GranularityDay  = "day"
+	GranularityHour = "hour"
+)
+
+// Ограничения на длину ряда, чтобы ответ оставался разумного размера
+const (
+	maxDayRange  = 366 * 24 * time.Hour
+	maxHourRange = 31 * 24 * time.Hour
+)
+
+type CostSeriesQuery struct {
+	Start, End     time.Time
+	Granularity    string // day | hour
+	GroupByService bool
+}
+
+// CostPoint - стоимость за интервал ряда. Cumulative - нарастающий итог с
+// начала месяца в таймзоне арендатора: на конец месяца он равен стоимости
+// использования в месячном счёте.
+type CostPoint struct {
+	Start      time.Time          `json:"start"`
+	End        time.Time          `json:"end"`
+	Cost       float64            `json:"cost"`
+	Cumulative float64            `json:"cumulative"`
+	ByService  map[string]float64 `json:"by_service,omitempty"` // service_id -> стоимость
+}
+
+type CostSeries struct {
+	TenantID    uuid.UUID   `json:"tenant_id"`
+	Currency    string      `json:"currency"`
+	Granularity string      `json:"granularity"`
+	Start       time.Time   `json:"start"`
+	End         time.Time   `json:"end"`
+	Total       float64     `json:"total"`
+	Points      []CostPoint `json:"points"`
+}
+
+// CostSeries строит ряд стоимости по usage_aggregates. Free tier
+// расходуется в хронологическом порядке с начала каждого месяца, поэтому
+// стоимость интервала - это прирост стоимости месяца-на-дату. В ряд входят
+// строки использования и скидки контракта; кредиты, доплата до минимального
+// платежа и налог считаются только в счёте.
+func (s *BillingService) CostSeries(tenantID uuid.UUID, q CostSeriesQuery) (*CostSeries, error) {
+	var tenant models.Tenant
+	if err := s.db.First(&tenant, "id = ?", tenantID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTenantNotFound
+		}
+		return nil, fmt.Errorf("failed to load tenant: %w", err)
+	}
+	loc, err := time.LoadLocation(tenant.Timezone)
+	if err != nil || tenant.Timezone == "" {
+		loc = time.UTC
+	}
+
+	if q.Granularity == "" {
+		q.Granularity = GranularityDay
+	}
+	if q.End.IsZero() {
+		q.End = time.Now()
+	}
+	if q.Start.IsZero() {
+		q.Start, _ = currentMonth(tenant, q.End)
+	}
+	maxRange := maxDayRange
+	switch q.Granularity {
+	case GranularityDay:
+	case GranularityHour:
+		maxRange = maxHourRange
+	default:
+		return nil, fmt.Errorf("%w: granularity must be day or hour", ErrInvalidCostQuery)
+	}
+	q.Start = truncateBucket(q.Start.In(loc), q.Granularity)
+	if !q.Start.Before(q.End) {
+		return nil, fmt.Errorf("%w: start must be before end", ErrInvalidCostQuery)
+	}
+	if q.End.Sub(q.Start) > maxRange {
+		return nil, fmt.Errorf("%w: range is too long for granularity %s", ErrInvalidCostQuery, q.Granularity)
+	}
+
+	series := &CostSeries{
+		TenantID:    tenant.ID,
+		Currency:    normalizeCurrency(tenant.Currency),
+		Granularity: q.Granularity,
+		Start:       q.Start,
+		End:         q.End,
+		Points:      []CostPoint{},
+	}
+
+	// каждый месяц - отдельный счёт со своим free tier
+	monthStart, _ := currentMonth(tenant, q.Start)
+	for ; monthStart.Before(q.End); monthStart = monthStart.AddDate(0, 1, 0) {
+		monthEnd := monthStart.AddDate(0, 1, 0)
+		to := monthEnd
+		if q.End.Before(to) {
+			to = q.End
+		}
+		points, err := s.monthCostSeries(tenant, monthStart, monthEnd, to, q)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range points {
+			if p.Start.Before(q.Start) {
+				continue
+			}
+			series.Total += p.Cost
+			series.Points = append(series.Points, p)
+		}
+	}
+
+	series.Total = roundMoney(series.Total)
+	for i := range series.Points {
+		p := &series.Points[i]
+		p.Cost, p.Cumulative = roundMoney(p.Cost), roundMoney(p.Cumulative)
+		for k, v := range p.ByService {
+			p.ByService[k] = roundMoney(v)
+		}
+	}
+	return series, nil
+}
+
+// monthCostSeries считает интервалы месяца [monthStart, to). Стоимость
+// отрезка плана пересчитывается по нарастающим итогам так же, как в
+// BillingService.calculate, и разница с предыдущим значением относится
+// к текущему интервалу.
+func (s *BillingService) monthCostSeries(tenant models.Tenant, monthStart, monthEnd, to time.Time, q CostSeriesQuery) ([]CostPoint, error) {
+	segments, err := planSegments(s.db, tenant, monthStart, monthEnd)
+	if err != nil {
+		return nil, err
+	}
+	currency := normalizeCurrency(tenant.Currency)
+	rates := make([]float64, len(segments))
+	for i, seg := range segments {
+		rates[i] = 1
+		if currency != normalizeCurrency(seg.Plan.Currency) {
+			rate, _, err := NewCurrencyService(s.db).PeriodRate(seg.Plan.Currency, currency, seg.End)
+			if err != nil {
+				return nil, err
+			}
+			rates[i] = rate
+		}
+	}
+
+	contract, err := activeContract(s.db, tenant.ID, monthStart, monthEnd)
+	if err != nil {
+		return nil, err
+	}
+	discount := func(code string) float64 {
+		if contract == nil || !discountableItems[code] {
+			return 0
+		}
+		if v, ok := contract.DimensionDiscounts[code].(float64); ok {
+			return v
+		}
+		return contract.DiscountPercent
+	}
+
+	// почасовой ряд не берёт дневные свёртки: их использование попало бы
+	// целиком в первый час суток
+	canonical := models.CanonicalAggregates
+	if q.Granularity == GranularityHour {
+		canonical = models.CanonicalAggregatesWithin(time.Hour)
+	}
+	var aggregates []models.UsageAggregate
+	if err := s.db.Scopes(canonical).Where(
+		"tenant_id = ? AND window_start >= ? AND window_start < ?",
+		tenant.ID, monthStart, to,
+	).Order("window_start").Find(&aggregates).Error; err != nil {
+		return nil, fmt.Errorf("failed to get usage aggregates: %w", err)
+	}
+
+	segTotals := make([]UsageTotals, len(segments))
+	segCosts := make([]map[string]float64, len(segments))
+	segmentOf := func(t time.Time) int {
+		for i, seg := range segments {
+			if !t.Before(seg.Start) && t.Before(seg.End) {
+				return i
+			}
+		}
+		return -1 // вне назначенных планов: в счёт не попадает
+	}
+
+	var points []CostPoint
+	var cumulative float64
+	next := 0
+	for bucket := monthStart; bucket.Before(to); bucket = nextBucket(bucket, q.Granularity) {
+		bucketEnd := nextBucket(bucket, q.Granularity)
+
+		bySeg := map[int][]models.UsageAggregate{}
+		var inBucket []models.UsageAggregate
+		for ; next < len(aggregates) && aggregates[next].WindowStart.Before(bucketEnd); next++ {
+			agg := aggregates[next]
+			if i := segmentOf(agg.WindowStart); i >= 0 {
+				bySeg[i] = append(bySeg[i], agg)
+				inBucket = append(inBucket, agg)
+			}
+		}
+
+		// прирост стоимости по кодам строк
+		delta := map[string]float64{}
+		for i := range segments {
+			aggs, ok := bySeg[i]
+			if !ok {
+				continue
+			}
+			segTotals[i] = addTotals(segTotals[i], s.calculateTotals(aggs))
+			var used UsageTotals
+			for j := 0; j < i; j++ {
+				used = addTotals(used, segTotals[j])
+			}
+			costs := map[string]float64{}
+			for _, it := range s.priceUsage(segTotals[i], remainingFreeTier(segments[i].Plan, used)) {
+				costs[it.Code] += it.TotalCost * rates[i] * (1 - discount(it.Code)/100)
+			}
+			for code, v := range costs {
+				delta[code] += v - segCosts[i][code]
+			}
+			segCosts[i] = costs
+		}
+
+		p := CostPoint{Start: bucket, End: bucketEnd}
+		for _, v := range delta {
+			p.Cost += v
+		}
+		cumulative += p.Cost
+		p.Cumulative = cumulative
+
+		if q.GroupByService && len(inBucket) > 0 {
+			p.ByService = serviceCosts(s, inBucket, delta)
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+// serviceCosts делит прирост стоимости интервала между сервисами
+// пропорционально их доле в объёме каждой статьи
+func serviceCosts(s *BillingService, aggs []models.UsageAggregate, delta map[string]float64) map[string]float64 {
+	byService := map[uuid.UUID][]models.UsageAggregate{}
+	for _, agg := range aggs {
+		byService[agg.ServiceID] = append(byService[agg.ServiceID], agg)
+	}
+	all := s.calculateTotals(aggs)
+	out := map[string]float64{}
+	for id, svcAggs := range byService {
+		shares := usageShares(s.calculateTotals(svcAggs), all)
+		var cost float64
+		for code, v := range delta {
+			cost += v * shares[code]
+		}
+		out[id.String()] = cost
+	}
+	return out
+}
+
+func truncateBucket(t time.Time, granularity string) time.Time {
+	if granularity == GranularityHour {
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func nextBucket(t time.Time, granularity string) time.Time {
+	if granularity == GranularityHour {
+		return t.Add(time.Hour)
+	}
+	return t.AddDate(0, 0, 1)
+}
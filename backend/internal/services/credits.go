@@ -0,0 +1,451 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lypolix/FaaS-billing/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrPromoCodeInvalid  = errors.New("promo code is invalid or expired")
+	ErrPromoCodeRedeemed = errors.New("promo code already redeemed by tenant")
+	ErrInsufficientFunds = errors.New("insufficient balance for adjustment")
+	ErrUnknownDimension  = errors.New("unknown dimension")
+)
+
+// CreditService ведёт журнал баланса арендатора: промо-кредиты,
+// предоплату и ручные корректировки
+type CreditService struct {
+	db *gorm.DB
+}
+
+func NewCreditService(db *gorm.DB) *CreditService {
+	return &CreditService{db: db}
+}
+
+type GrantRequest struct {
+	Kind       string     `json:"kind"` // promo_credit, top_up, adjustment
+	Amount     float64    `json:"amount" binding:"required"`
+	Currency   string     `json:"currency"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	Dimensions []string   `json:"dimensions"`
+	Note       string     `json:"note"`
+}
+
+// Grant начисляет кредит. Отрицательная корректировка списывается с
+// действующих начислений в порядке их сгорания.
+func (s *CreditService) Grant(tenantID uuid.UUID, req GrantRequest) ([]models.BalanceEntry, error) {
+	switch req.Kind {
+	case models.LedgerPromoCredit, models.LedgerTopUp:
+		if req.Amount <= 0 {
+			return nil, fmt.Errorf("amount must be positive for %s", req.Kind)
+		}
+	case models.LedgerAdjustment:
+		if req.Amount == 0 {
+			return nil, fmt.Errorf("amount must not be zero")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported kind %q, use promo_credit, top_up or adjustment", req.Kind)
+	}
+	if err := ValidateDimensions(req.Dimensions); err != nil {
+		return nil, err
+	}
+
+	var out []models.BalanceEntry
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var tenant models.Tenant
+		if err := tx.First(&tenant, "id = ?", tenantID).Error; err != nil {
+			return fmt.Errorf("tenant not found: %w", err)
+		}
+		currency := normalizeCurrency(req.Currency)
+		if req.Currency == "" {
+			currency = normalizeCurrency(tenant.Currency)
+		}
+
+		if req.Amount > 0 {
+			e := models.BalanceEntry{
+				ID:         uuid.New(),
+				TenantID:   tenantID,
+				Kind:       req.Kind,
+				Amount:     roundMoney(req.Amount),
+				Currency:   currency,
+				Dimensions: joinDimensions(req.Dimensions),
+				ExpiresAt:  req.ExpiresAt,
+				Note:       req.Note,
+			}
+			if err := tx.Create(&e).Error; err != nil {
+				return err
+			}
+			out = append(out, e)
+			return nil
+		}
+
+		// отрицательная корректировка
+		if err := lockLedger(tx, tenantID); err != nil {
+			return err
+		}
+		now := time.Now()
+		grants, err := activeGrants(tx, tenantID, currency, now, now)
+		if err != nil {
+			return err
+		}
+		left := roundMoney(-req.Amount)
+		for _, g := range grants {
+			if left <= 0 {
+				break
+			}
+			take := minMoney(left, g.Remaining)
+			e := models.BalanceEntry{
+				ID:       uuid.New(),
+				TenantID: tenantID,
+				Kind:     models.LedgerAdjustment,
+				Amount:   -take,
+				Currency: currency,
+				GrantID:  &g.Entry.ID,
+				Note:     req.Note,
+			}
+			if err := tx.Create(&e).Error; err != nil {
+				return err
+			}
+			out = append(out, e)
+			left = roundMoney(left - take)
+		}
+		if left > 0 {
+			return fmt.Errorf("%w: %.2f %s short", ErrInsufficientFunds, left, currency)
+		}
+		return nil
+	})
+	return out, err
+}
+
+// RedeemPromoCode активирует промокод: каждый арендатор может
+// использовать код один раз
+func (s *CreditService) RedeemPromoCode(tenantID uuid.UUID, code string) (*models.BalanceEntry, error) {
+	var out *models.BalanceEntry
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var promo models.PromoCode
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&promo, "code = ? AND active = ?", strings.TrimSpace(code), true).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrPromoCodeInvalid
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if promo.RedeemBy != nil && now.After(*promo.RedeemBy) {
+			return ErrPromoCodeInvalid
+		}
+		if promo.MaxRedemptions > 0 && promo.Redemptions >= promo.MaxRedemptions {
+			return ErrPromoCodeInvalid
+		}
+
+		var used int64
+		if err := tx.Model(&models.BalanceEntry{}).
+			Where("tenant_id = ? AND promo_code_id = ?", tenantID, promo.ID).
+			Count(&used).Error; err != nil {
+			return err
+		}
+		if used > 0 {
+			return ErrPromoCodeRedeemed
+		}
+
+		var tenant models.Tenant
+		if err := tx.First(&tenant, "id = ?", tenantID).Error; err != nil {
+			return fmt.Errorf("tenant not found: %w", err)
+		}
+		currency := normalizeCurrency(promo.Currency)
+		if promo.Currency == "" {
+			currency = normalizeCurrency(tenant.Currency)
+		}
+
+		var expires *time.Time
+		if promo.CreditValidityDays > 0 {
+			t := now.AddDate(0, 0, promo.CreditValidityDays)
+			expires = &t
+		}
+		e := models.BalanceEntry{
+			ID:          uuid.New(),
+			TenantID:    tenantID,
+			Kind:        models.LedgerPromoCredit,
+			Amount:      roundMoney(promo.Amount),
+			Currency:    currency,
+			Dimensions:  promo.Dimensions,
+			ExpiresAt:   expires,
+			PromoCodeID: &promo.ID,
+			Note:        "promo code " + promo.Code,
+		}
+		if err := tx.Create(&e).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.PromoCode{}).Where("id = ?", promo.ID).
+			Update("redemptions", gorm.Expr("redemptions + 1")).Error; err != nil {
+			return err
+		}
+		out = &e
+		return nil
+	})
+	return out, err
+}
+
+type LedgerView struct {
+	Entries  []models.BalanceEntry `json:"entries"`
+	Balances map[string]float64    `json:"balances"` // остаток действующих кредитов по валютам
+}
+
+func (s *CreditService) Ledger(tenantID uuid.UUID) (*LedgerView, error) {
+	var entries []models.BalanceEntry
+	if err := s.db.Where("tenant_id = ?", tenantID).Order("created_at").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	grants, err := activeGrants(s.db, tenantID, "", now, now)
+	if err != nil {
+		return nil, err
+	}
+	balances := map[string]float64{}
+	for _, g := range grants {
+		balances[g.Entry.Currency] = roundMoney(balances[g.Entry.Currency] + g.Remaining)
+	}
+	return &LedgerView{Entries: entries, Balances: balances}, nil
+}
+
+type grantBalance struct {
+	Entry     models.BalanceEntry
+	Remaining float64
+}
+
+// activeGrants возвращает начисления с положительным остатком, начисленные
+// раньше createdBefore и не сгоревшие к моменту validUntil, в порядке применения: сначала
+// кредиты с ограничением по статьям, затем раньше сгорающие, предоплата -
+// последней
+func activeGrants(db *gorm.DB, tenantID uuid.UUID, currency string, createdBefore, validUntil time.Time) ([]grantBalance, error) {
+	var grants []models.BalanceEntry
+	q := db.Where("tenant_id = ? AND grant_id IS NULL AND amount > 0", tenantID).
+		Where("created_at < ?", createdBefore).
+		Where("expires_at IS NULL OR expires_at >= ?", validUntil)
+	if currency != "" {
+		q = q.Where("currency = ?", currency)
+	}
+	if err := q.Find(&grants).Error; err != nil {
+		return nil, err
+	}
+	if len(grants) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(grants))
+	for _, g := range grants {
+		ids = append(ids, g.ID)
+	}
+	var sums []struct {
+		GrantID uuid.UUID
+		Total   float64
+	}
+	if err := db.Model(&models.BalanceEntry{}).
+		Select("grant_id, SUM(amount) AS total").
+		Where("grant_id IN ?", ids).
+		Group("grant_id").
+		Scan(&sums).Error; err != nil {
+		return nil, err
+	}
+	used := map[uuid.UUID]float64{}
+	for _, s := range sums {
+		used[s.GrantID] = s.Total
+	}
+
+	var out []grantBalance
+	for _, g := range grants {
+		if rem := roundMoney(g.Amount + used[g.ID]); rem > 0 {
+			out = append(out, grantBalance{Entry: g, Remaining: rem})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i].Entry, out[j].Entry
+		if (a.Kind == models.LedgerTopUp) != (b.Kind == models.LedgerTopUp) {
+			return b.Kind == models.LedgerTopUp
+		}
+		if (a.Dimensions != "") != (b.Dimensions != "") {
+			return a.Dimensions != ""
+		}
+		if a.ExpiresAt != nil && b.ExpiresAt != nil && !a.ExpiresAt.Equal(*b.ExpiresAt) {
+			return a.ExpiresAt.Before(*b.ExpiresAt)
+		}
+		if (a.ExpiresAt != nil) != (b.ExpiresAt != nil) {
+			return a.ExpiresAt != nil
+		}
+		return a.CreatedAt.Before(b.CreatedAt)
+	})
+	return out, nil
+}
+
+// applyCredits списывает доступные кредиты со строк счёта (после free tier,
+// до налога) и добавляет строку credits с отрицательной суммой. Применяются
+// кредиты, начисленные до конца периода и действующие до его конца: кредит,
+// сгоревший в середине периода, на этот счёт не идёт. В БД ничего не
+// пишется: списания фиксируются при финализации счёта.
+func applyCredits(db *gorm.DB, result *models.BillingResult) error {
+	grants, err := activeGrants(db, result.TenantID, normalizeCurrency(result.Currency), result.PeriodEnd, result.PeriodEnd)
+	if err != nil {
+		return err
+	}
+	allocateCredits(result, grants)
+	return nil
+}
+
+// allocateCredits распределяет остатки grants (в порядке activeGrants) по
+// строкам расчёта
+func allocateCredits(result *models.BillingResult, grants []grantBalance) {
+	// остаток к оплате по каждой строке; доплата до минимального платежа
+	// кредитами не покрывается, иначе промо-кредит гасил бы обязательство
+	due := make(map[string]float64)
+	for _, it := range result.LineItems {
+		if it.Code == models.LineItemCommitTrueUp {
+			continue
+		}
+		code := it.Code
+		if it.AppliesTo != "" {
+			code = it.AppliesTo // скидка уменьшает сумму своей статьи
+		}
+		due[code] = roundMoney(due[code] + it.TotalCost)
+	}
+
+	var applied, remaining float64
+	for _, g := range grants {
+		left := g.Remaining
+		dims := splitDimensions(g.Entry.Dimensions)
+		for _, it := range result.LineItems {
+			if left <= 0 {
+				break
+			}
+			if due[it.Code] <= 0 || (len(dims) > 0 && !dims[it.Code]) {
+				continue
+			}
+			take := minMoney(left, due[it.Code])
+			due[it.Code] = roundMoney(due[it.Code] - take)
+			left = roundMoney(left - take)
+		}
+		if used := roundMoney(g.Remaining - left); used > 0 {
+			applied = roundMoney(applied + used)
+			result.Credits.Allocations = append(result.Credits.Allocations, models.CreditAllocation{
+				GrantID: g.Entry.ID,
+				Amount:  used,
+			})
+		}
+		remaining = roundMoney(remaining + left)
+	}
+
+	result.Credits.Applied = applied
+	result.Credits.BalanceRemaining = remaining
+	if applied > 0 {
+		result.LineItems = append(result.LineItems, models.BillingLineItem{
+			Code:           models.LineItemCredits,
+			Unit:           result.Currency,
+			Description:    lineItemDescription(models.LineItemCredits),
+			Quantity:       applied,
+			UnitPrice:      -1,
+			BillableAmount: applied,
+			TotalCost:      -applied,
+			Currency:       result.Currency,
+		})
+	}
+}
+
+// recordCreditApplications пишет в журнал списания по финализированному счёту
+func recordCreditApplications(tx *gorm.DB, bill *models.Bill, credits models.CreditSummary) error {
+	for _, a := range credits.Allocations {
+		grantID := a.GrantID
+		e := models.BalanceEntry{
+			ID:       uuid.New(),
+			TenantID: bill.TenantID,
+			Kind:     models.LedgerCreditApplied,
+			Amount:   -a.Amount,
+			Currency: bill.Currency,
+			GrantID:  &grantID,
+			BillID:   &bill.ID,
+		}
+		if err := tx.Create(&e).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reverseCreditApplications возвращает кредиты, списанные аннулируемым счётом
+func reverseCreditApplications(tx *gorm.DB, bill *models.Bill) error {
+	var applied []models.BalanceEntry
+	if err := tx.Where("bill_id = ? AND kind = ?", bill.ID, models.LedgerCreditApplied).
+		Find(&applied).Error; err != nil {
+		return err
+	}
+	for _, a := range applied {
+		e := models.BalanceEntry{
+			ID:       uuid.New(),
+			TenantID: a.TenantID,
+			Kind:     models.LedgerCreditReversal,
+			Amount:   -a.Amount,
+			Currency: a.Currency,
+			GrantID:  a.GrantID,
+			BillID:   &bill.ID,
+			Note:     "bill voided",
+		}
+		if err := tx.Create(&e).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockLedger сериализует списания по арендатору до конца транзакции
+func lockLedger(tx *gorm.DB, tenantID uuid.UUID) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "ledger:"+tenantID.String()).Error
+}
+
+// ValidateDimensions проверяет, что кредит ограничен известными статьями
+// использования: опечатка в коде сделала бы кредит неприменимым
+func ValidateDimensions(dims []string) error {
+	for _, d := range dims {
+		if d = strings.TrimSpace(d); d != "" && !discountableItems[d] {
+			return fmt.Errorf("%w %q", ErrUnknownDimension, d)
+		}
+	}
+	return nil
+}
+
+func joinDimensions(dims []string) string {
+	var out []string
+	for _, d := range dims {
+		if d = strings.TrimSpace(d); d != "" {
+			out = append(out, d)
+		}
+	}
+	return strings.Join(out, ",")
+}
+
+func splitDimensions(s string) map[string]bool {
+	if s == "" {
+		return nil
+	}
+	out := map[string]bool{}
+	for _, d := range strings.Split(s, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			out[d] = true
+		}
+	}
+	return out
+}
+
+func minMoney(a, b float64) float64 {
+	if a < b {
+		return roundMoney(a)
+	}
+	return roundMoney(b)
+}
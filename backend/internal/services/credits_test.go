@@ -0,0 +1,101 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/lypolix/FaaS-billing/internal/models"
+)
+
+func TestAllocateCredits(t *testing.T) {
+	grant := func(amount float64, dims string) grantBalance {
+		return grantBalance{
+			Entry:     models.BalanceEntry{ID: uuid.New(), Kind: models.LedgerPromoCredit, Amount: amount, Dimensions: dims},
+			Remaining: amount,
+		}
+	}
+	tests := []struct {
+		name               string
+		items              []models.BillingLineItem
+		grants             []grantBalance
+		applied, remaining float64
+		allocations        []float64
+	}{
+		{
+			name:        "no grants",
+			items:       []models.BillingLineItem{{Code: models.LineItemInvocations, TotalCost: 20}},
+			allocations: []float64{},
+		},
+		{
+			name: "grant covers lines in order",
+			items: []models.BillingLineItem{
+				{Code: models.LineItemInvocations, TotalCost: 20},
+				{Code: models.LineItemComputeGBHours, TotalCost: 20},
+			},
+			grants:      []grantBalance{grant(30, "")},
+			applied:     30,
+			allocations: []float64{30},
+		},
+		{
+			name:        "restricted grant skips other dimensions",
+			items:       []models.BillingLineItem{{Code: models.LineItemInvocations, TotalCost: 20}},
+			grants:      []grantBalance{grant(10, models.LineItemEgressGB), grant(5, "")},
+			applied:     5,
+			remaining:   10,
+			allocations: []float64{5},
+		},
+		{
+			name: "discount reduces what its line owes",
+			items: []models.BillingLineItem{
+				{Code: models.LineItemInvocations, TotalCost: 100},
+				{Code: models.LineItemDiscount, AppliesTo: models.LineItemInvocations, TotalCost: -40},
+			},
+			grants:      []grantBalance{grant(100, "")},
+			applied:     60,
+			remaining:   40,
+			allocations: []float64{60},
+		},
+		{
+			name: "commit true-up is not covered",
+			items: []models.BillingLineItem{
+				{Code: models.LineItemInvocations, TotalCost: 10},
+				{Code: models.LineItemCommitTrueUp, TotalCost: 90},
+			},
+			grants:      []grantBalance{grant(100, "")},
+			applied:     10,
+			remaining:   90,
+			allocations: []float64{10},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &models.BillingResult{LineItems: append([]models.BillingLineItem(nil), tt.items...), Currency: "RUB"}
+			allocateCredits(result, tt.grants)
+
+			if result.Credits.Applied != tt.applied || result.Credits.BalanceRemaining != tt.remaining {
+				t.Errorf("applied/remaining = %v/%v, want %v/%v",
+					result.Credits.Applied, result.Credits.BalanceRemaining, tt.applied, tt.remaining)
+			}
+			if len(result.Credits.Allocations) != len(tt.allocations) {
+				t.Fatalf("allocations = %+v, want %v", result.Credits.Allocations, tt.allocations)
+			}
+			for i, a := range result.Credits.Allocations {
+				if a.Amount != tt.allocations[i] {
+					t.Errorf("allocation %d = %v, want %v", i, a.Amount, tt.allocations[i])
+				}
+			}
+
+			added := result.LineItems[len(tt.items):]
+			if tt.applied == 0 {
+				if len(added) != 0 {
+					t.Errorf("unexpected lines %+v", added)
+				}
+				return
+			}
+			if len(added) != 1 || added[0].Code != models.LineItemCredits || added[0].TotalCost != -tt.applied {
+				t.Errorf("credits line = %+v, want one %s line of %v", added, models.LineItemCredits, -tt.applied)
+			}
+		})
+	}
+}
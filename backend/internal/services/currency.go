@@ -0,0 +1,188 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lypolix/FaaS-billing/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrNoExchangeRate = errors.New("no exchange rate available")
+
+type CurrencyService struct {
+	db *gorm.DB
+}
+
+func NewCurrencyService(db *gorm.DB) *CurrencyService {
+	return &CurrencyService{db: db}
+}
+
+// Rate возвращает курс from -> to, действующий на момент at: последний
+// курс с датой не позже at. Если прямого курса нет, используется обратный.
+func (s *CurrencyService) Rate(from, to string, at time.Time) (float64, time.Time, error) {
+	return s.rate(from, to, "rate_date <= ?", at)
+}
+
+// PeriodRate - курс на конец периода с исключённой границей end: последний
+// курс с датой раньше end, чтобы курс первого дня следующего периода не
+// попадал в текущий
+func (s *CurrencyService) PeriodRate(from, to string, end time.Time) (float64, time.Time, error) {
+	return s.rate(from, to, "rate_date < ?", end)
+}
+
+func (s *CurrencyService) rate(from, to, dateCond string, at time.Time) (float64, time.Time, error) {
+	from, to = normalizeCurrency(from), normalizeCurrency(to)
+	if from == to {
+		return 1, at, nil
+	}
+
+	var r models.ExchangeRate
+	err := s.db.Where("base_currency = ? AND quote_currency = ? AND "+dateCond, from, to, at).
+		Order("rate_date DESC").First(&r).Error
+	if err == nil {
+		return r.Rate, r.RateDate, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, time.Time{}, err
+	}
+
+	err = s.db.Where("base_currency = ? AND quote_currency = ? AND "+dateCond, to, from, at).
+		Order("rate_date DESC").First(&r).Error
+	if err == nil && r.Rate > 0 {
+		return 1 / r.Rate, r.RateDate, nil
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, time.Time{}, err
+	}
+	return 0, time.Time{}, fmt.Errorf("%w: %s -> %s on %s", ErrNoExchangeRate, from, to, at.Format("2006-01-02"))
+}
+
+// UpsertRates сохраняет курсы; курс на ту же дату перезаписывается
+func (s *CurrencyService) UpsertRates(rates []models.ExchangeRate) error {
+	for i := range rates {
+		r := &rates[i]
+		r.BaseCurrency = normalizeCurrency(r.BaseCurrency)
+		r.QuoteCurrency = normalizeCurrency(r.QuoteCurrency)
+		if len(r.BaseCurrency) != 3 || len(r.QuoteCurrency) != 3 {
+			return fmt.Errorf("invalid currency pair %q/%q", r.BaseCurrency, r.QuoteCurrency)
+		}
+		if r.BaseCurrency == r.QuoteCurrency {
+			return fmt.Errorf("base and quote currency are the same: %s", r.BaseCurrency)
+		}
+		if r.Rate <= 0 {
+			return fmt.Errorf("rate for %s/%s must be positive", r.BaseCurrency, r.QuoteCurrency)
+		}
+		if r.RateDate.IsZero() {
+			return fmt.Errorf("rate_date is required for %s/%s", r.BaseCurrency, r.QuoteCurrency)
+		}
+		r.RateDate = r.RateDate.UTC().Truncate(24 * time.Hour)
+		if r.Source == "" {
+			r.Source = "api"
+		}
+	}
+	if len(rates) == 0 {
+		return nil
+	}
+	return s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "base_currency"}, {Name: "quote_currency"}, {Name: "rate_date"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate", "source"}),
+	}).Create(&rates).Error
+}
+
+// LoadRatesFile загружает курсы из JSON (массив ExchangeRate) или CSV
+// с колонками base,quote,rate,date (YYYY-MM-DD)
+func (s *CurrencyService) LoadRatesFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var rates []models.ExchangeRate
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		rates, err = parseRatesCSV(f)
+	} else {
+		err = json.NewDecoder(f).Decode(&rates)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i := range rates {
+		rates[i].Source = "file"
+	}
+	if err := s.UpsertRates(rates); err != nil {
+		return 0, err
+	}
+	return len(rates), nil
+}
+
+func parseRatesCSV(r io.Reader) ([]models.ExchangeRate, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var out []models.ExchangeRate
+	for i, rec := range records {
+		if len(rec) < 4 {
+			return nil, fmt.Errorf("line %d: expected base,quote,rate,date", i+1)
+		}
+		if i == 0 && strings.EqualFold(strings.TrimSpace(rec[0]), "base") {
+			continue // заголовок
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(rec[2]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid rate: %w", i+1, err)
+		}
+		date, err := time.Parse("2006-01-02", strings.TrimSpace(rec[3]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date: %w", i+1, err)
+		}
+		out = append(out, models.ExchangeRate{
+			BaseCurrency:  rec[0],
+			QuoteCurrency: rec[1],
+			Rate:          rate,
+			RateDate:      date,
+		})
+	}
+	return out, nil
+}
+
+// convertResult пересчитывает строки расчёта из валюты плана в валюту
+// арендатора по курсу rate и фиксирует курс в результате и в каждой строке
+func convertResult(result *models.BillingResult, to string, rate float64, rateDate time.Time) {
+	from := result.Currency
+	result.SourceCurrency = from
+	result.Currency = to
+	result.ExchangeRate = rate
+	result.ExchangeRateDate = &rateDate
+	for i := range result.LineItems {
+		it := &result.LineItems[i]
+		it.UnitPrice = roundRate(it.UnitPrice * rate)
+		it.TotalCost = roundMoney(it.TotalCost * rate)
+		it.Currency = to
+		it.SourceCurrency, it.ExchangeRate, it.ExchangeRateDate = from, rate, &rateDate
+	}
+}
+
+func roundRate(v float64) float64 {
+	return math.Round(v*1e6) / 1e6
+}
+
+func normalizeCurrency(c string) string {
+	c = strings.ToUpper(strings.TrimSpace(c))
+	if c == "" {
+		return "RUB"
+	}
+	return c
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lypolix/FaaS-billing/internal/models"
+)
+
+func TestConvertResult(t *testing.T) {
+	rateDate := time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		rate      float64
+		unitPrice float64
+		cost      float64
+		wantPrice float64
+		wantCost  float64
+	}{
+		{name: "usd to rub", rate: 90, unitPrice: 0.0002, cost: 10.01, wantPrice: 0.018, wantCost: 900.9},
+		{name: "money rounded to cents", rate: 0.011, unitPrice: 1.5, cost: 123.45, wantPrice: 0.0165, wantCost: 1.36},
+		{name: "unit price rounded to 6 digits", rate: 1.0000001, unitPrice: 0.1234567, cost: 1, wantPrice: 0.123457, wantCost: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &models.BillingResult{
+				Currency:  "USD",
+				LineItems: []models.BillingLineItem{{Code: models.LineItemInvocations, UnitPrice: tt.unitPrice, TotalCost: tt.cost, Currency: "USD"}},
+			}
+			convertResult(result, "RUB", tt.rate, rateDate)
+
+			if result.Currency != "RUB" || result.SourceCurrency != "USD" || result.ExchangeRate != tt.rate || !result.ExchangeRateDate.Equal(rateDate) {
+				t.Errorf("result currency %s<-%s at %v on %v", result.Currency, result.SourceCurrency, result.ExchangeRate, result.ExchangeRateDate)
+			}
+			it := result.LineItems[0]
+			if !approx(it.UnitPrice, tt.wantPrice) || !approx(it.TotalCost, tt.wantCost) {
+				t.Errorf("line price/cost = %v/%v, want %v/%v", it.UnitPrice, it.TotalCost, tt.wantPrice, tt.wantCost)
+			}
+			if it.Currency != "RUB" || it.SourceCurrency != "USD" || it.ExchangeRate != tt.rate || !it.ExchangeRateDate.Equal(rateDate) {
+				t.Errorf("line currency %s<-%s at %v on %v", it.Currency, it.SourceCurrency, it.ExchangeRate, it.ExchangeRateDate)
+			}
+		})
+	}
+}
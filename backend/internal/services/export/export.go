@@ -0,0 +1,288 @@
+// Package export выгружает агрегаты использования и строки счетов в CSV и
+// Parquet. Схема колонок задаётся структурами строк (тег parquet) и общая
+// для обоих форматов; данные читаются курсором, без загрузки в память.
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/lypolix/FaaS-billing/internal/models"
+)
+
+// Форматы выгрузки
+const (
+	FormatCSV     = "csv"
+	FormatParquet = "parquet"
+)
+
+// ContentType - MIME-тип формата
+func ContentType(format string) string {
+	if format == FormatParquet {
+		return "application/vnd.apache.parquet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// UsageRow - строка выгрузки usage_aggregates. Порядок и имена колонок
+// стабильны: новые колонки добавляются только в конец.
+type UsageRow struct {
+	ID                 int64     `parquet:"id"`
+	WindowStart        time.Time `parquet:"window_start"`
+	WindowEnd          time.Time `parquet:"window_end"`
+	WindowSize         string    `parquet:"window_size"`
+	TenantID           string    `parquet:"tenant_id"`
+	ServiceID          string    `parquet:"service_id"`
+	RevisionID         *string   `parquet:"revision_id,optional"`
+	Invocations        int64     `parquet:"invocations"`
+	TotalDurationMS    int64     `parquet:"total_duration_ms"`
+	BillableDurationMS int64     `parquet:"billable_duration_ms"`
+	AvgDurationMS      float64   `parquet:"avg_duration_ms"`
+	P50DurationMS      float64   `parquet:"p50_duration_ms"`
+	P95DurationMS      float64   `parquet:"p95_duration_ms"`
+	MaxMemoryMB        float64   `parquet:"max_memory_mb"`
+	AvgMemoryMB        float64   `parquet:"avg_memory_mb"`
+	TotalMemoryMBHours float64   `parquet:"total_memory_mb_hours"`
+	ColdStarts         int64     `parquet:"cold_starts"`
+	ColdStartInitMS    int64     `parquet:"cold_start_init_ms"`
+	ColdStartGBSeconds float64   `parquet:"cold_start_gb_seconds"`
+	Errors             int64     `parquet:"errors"`
+	EgressBytes        int64     `parquet:"egress_bytes"`
+	TotalCPUMS         int64     `parquet:"total_cpu_ms"`
+}
+
+func usageRow(a models.UsageAggregate) UsageRow {
+	r := UsageRow{
+		ID:                 int64(a.ID),
+		WindowStart:        a.WindowStart.UTC(),
+		WindowEnd:          a.WindowEnd.UTC(),
+		WindowSize:         a.WindowSize,
+		TenantID:           a.TenantID.String(),
+		ServiceID:          a.ServiceID.String(),
+		Invocations:        a.Invocations,
+		TotalDurationMS:    a.TotalDurationMS,
+		BillableDurationMS: a.BillableDurationMS,
+		AvgDurationMS:      a.AvgDurationMS,
+		P50DurationMS:      a.P50DurationMS,
+		P95DurationMS:      a.P95DurationMS,
+		MaxMemoryMB:        a.MaxMemoryMB,
+		AvgMemoryMB:        a.AvgMemoryMB,
+		TotalMemoryMBHours: a.TotalMemoryMBHours,
+		ColdStarts:         int64(a.ColdStarts),
+		ColdStartInitMS:    a.ColdStartInitMS,
+		ColdStartGBSeconds: a.ColdStartGBSeconds,
+		Errors:             int64(a.Errors),
+		EgressBytes:        a.EgressBytes,
+		TotalCPUMS:         a.TotalCPUMS,
+	}
+	if a.RevisionID != nil {
+		s := a.RevisionID.String()
+		r.RevisionID = &s
+	}
+	return r
+}
+
+// LineItemRow - строка счёта вместе с реквизитами счёта
+type LineItemRow struct {
+	BillID         string     `parquet:"bill_id"`
+	InvoiceNumber  *string    `parquet:"invoice_number,optional"`
+	TenantID       string     `parquet:"tenant_id"`
+	BillStatus     string     `parquet:"bill_status"`
+	BillStart      time.Time  `parquet:"bill_period_start"`
+	BillEnd        time.Time  `parquet:"bill_period_end"`
+	Code           string     `parquet:"code"`
+	AppliesTo      string     `parquet:"applies_to"`
+	Unit           string     `parquet:"unit"`
+	Description    string     `parquet:"description"`
+	Quantity       float64    `parquet:"quantity"`
+	UnitPrice      float64    `parquet:"unit_price"`
+	FreeTierUsed   float64    `parquet:"free_tier_used"`
+	BillableAmount float64    `parquet:"billable_amount"`
+	TotalCost      float64    `parquet:"total_cost"`
+	Currency       string     `parquet:"currency"`
+	PeriodStart    *time.Time `parquet:"period_start,optional"`
+	PeriodEnd      *time.Time `parquet:"period_end,optional"`
+	PricingPlanID  *string    `parquet:"pricing_plan_id,optional"`
+	PlanVersion    int64      `parquet:"plan_version"`
+	SourceCurrency string     `parquet:"source_currency"`
+	ExchangeRate   float64    `parquet:"exchange_rate"`
+	RateDate       *time.Time `parquet:"exchange_rate_date,optional"`
+}
+
+// UsageFilter - фильтр выгрузки агрегатов; пустые поля не ограничивают
+type UsageFilter struct {
+	TenantID   *uuid.UUID
+	ServiceID  *uuid.UUID
+	Start, End *time.Time
+	WindowSize string
+}
+
+// Usage пишет агрегаты в w в формате format и возвращает число строк
+func Usage(db *gorm.DB, f UsageFilter, format string, w io.Writer) (int64, error) {
+	q := db.Model(&models.UsageAggregate{})
+	if f.TenantID != nil {
+		q = q.Where("tenant_id = ?", *f.TenantID)
+	}
+	if f.ServiceID != nil {
+		q = q.Where("service_id = ?", *f.ServiceID)
+	}
+	if f.Start != nil {
+		q = q.Where("window_start >= ?", *f.Start)
+	}
+	if f.End != nil {
+		q = q.Where("window_end <= ?", *f.End)
+	}
+	if f.WindowSize != "" {
+		q = q.Where("window_size = ?", f.WindowSize)
+	}
+
+	out, err := newWriter[UsageRow](format, w)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := q.Order("window_start, id").Rows()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var n int64
+	for rows.Next() {
+		var agg models.UsageAggregate
+		if err := db.ScanRows(rows, &agg); err != nil {
+			return n, err
+		}
+		if err := out.Write(usageRow(agg)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	return n, out.Close()
+}
+
+// LineItemFilter - фильтр выгрузки строк счетов по периоду счёта и
+// стабильным кодам строк (пусто - все коды)
+type LineItemFilter struct {
+	TenantID   *uuid.UUID
+	Status     string
+	Start, End *time.Time
+	Codes      []string
+}
+
+// SplitCodes разбирает список кодов строк через запятую
+func SplitCodes(s string) []string {
+	var out []string
+	for _, code := range strings.Split(s, ",") {
+		if code = strings.TrimSpace(code); code != "" {
+			out = append(out, code)
+		}
+	}
+	return out
+}
+
+// LineItems пишет строки счетов (по одной на строку счёта)
+func LineItems(db *gorm.DB, f LineItemFilter, format string, w io.Writer) (int64, error) {
+	q := db.Model(&models.Bill{})
+	if f.TenantID != nil {
+		q = q.Where("tenant_id = ?", *f.TenantID)
+	}
+	if f.Status != "" {
+		q = q.Where("status = ?", f.Status)
+	}
+	if f.Start != nil {
+		q = q.Where("period_start >= ?", *f.Start)
+	}
+	if f.End != nil {
+		q = q.Where("period_end <= ?", *f.End)
+	}
+	codes := make(map[string]bool, len(f.Codes))
+	for _, c := range f.Codes {
+		codes[c] = true
+	}
+
+	out, err := newWriter[LineItemRow](format, w)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := q.Order("period_start, id").Rows()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var n int64
+	for rows.Next() {
+		var bill models.Bill
+		if err := db.ScanRows(rows, &bill); err != nil {
+			return n, err
+		}
+		items, err := billItems(bill)
+		if err != nil {
+			return n, fmt.Errorf("bill %s: %w", bill.ID, err)
+		}
+		for _, it := range items {
+			if len(codes) > 0 && !codes[it.Code] {
+				continue
+			}
+			r := LineItemRow{
+				BillID:         bill.ID.String(),
+				InvoiceNumber:  bill.InvoiceNumber,
+				TenantID:       bill.TenantID.String(),
+				BillStatus:     bill.Status,
+				BillStart:      bill.PeriodStart.UTC(),
+				BillEnd:        bill.PeriodEnd.UTC(),
+				Code:           it.Code,
+				AppliesTo:      it.AppliesTo,
+				Unit:           it.Unit,
+				Description:    it.Description,
+				Quantity:       it.Quantity,
+				UnitPrice:      it.UnitPrice,
+				FreeTierUsed:   it.FreeTierUsed,
+				BillableAmount: it.BillableAmount,
+				TotalCost:      it.TotalCost,
+				Currency:       it.Currency,
+				PeriodStart:    it.PeriodStart,
+				PeriodEnd:      it.PeriodEnd,
+				PlanVersion:    int64(it.PlanVersion),
+				SourceCurrency: it.SourceCurrency,
+				ExchangeRate:   it.ExchangeRate,
+				RateDate:       it.ExchangeRateDate,
+			}
+			if it.PricingPlanID != nil {
+				s := it.PricingPlanID.String()
+				r.PricingPlanID = &s
+			}
+			if err := out.Write(r); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	return n, out.Close()
+}
+
+func billItems(bill models.Bill) ([]models.BillingLineItem, error) {
+	raw, ok := bill.LineItems["items"]
+	if !ok {
+		return nil, nil
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var items []models.BillingLineItem
+	err = json.Unmarshal(b, &items)
+	return items, err
+}
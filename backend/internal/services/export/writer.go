@@ -0,0 +1,117 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// rowGroupSize ограничивает число строк, которые Parquet держит в памяти
+// до сброса группы строк в w
+const rowGroupSize = 10_000
+
+type rowWriter[T any] interface {
+	Write(row T) error
+	Close() error
+}
+
+func newWriter[T any](format string, w io.Writer) (rowWriter[T], error) {
+	switch format {
+	case "", FormatCSV:
+		return newCSVWriter[T](w)
+	case FormatParquet:
+		return &parquetWriter[T]{w: parquet.NewGenericWriter[T](w, parquet.MaxRowsPerRowGroup(rowGroupSize))}, nil
+	default:
+		return nil, fmt.Errorf("unsupported format %q, use csv or parquet", format)
+	}
+}
+
+type parquetWriter[T any] struct {
+	w   *parquet.GenericWriter[T]
+	buf []T
+}
+
+func (p *parquetWriter[T]) Write(row T) error {
+	p.buf = append(p.buf, row)
+	if len(p.buf) < 1000 {
+		return nil
+	}
+	return p.flush()
+}
+
+func (p *parquetWriter[T]) flush() error {
+	if len(p.buf) == 0 {
+		return nil
+	}
+	_, err := p.w.Write(p.buf)
+	p.buf = p.buf[:0]
+	return err
+}
+
+func (p *parquetWriter[T]) Close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+	return p.w.Close()
+}
+
+// csvWriter пишет колонки в порядке полей структуры, имена - из тега parquet
+type csvWriter[T any] struct {
+	w      *csv.Writer
+	record []string
+}
+
+func newCSVWriter[T any](w io.Writer) (*csvWriter[T], error) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	header := make([]string, t.NumField())
+	for i := range header {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("parquet"), ",")
+		header[i] = name
+	}
+	cw := &csvWriter[T]{w: csv.NewWriter(w), record: make([]string, len(header))}
+	if err := cw.w.Write(header); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (c *csvWriter[T]) Write(row T) error {
+	v := reflect.ValueOf(row)
+	for i := range c.record {
+		c.record[i] = csvValue(v.Field(i))
+	}
+	return c.w.Write(c.record)
+}
+
+func (c *csvWriter[T]) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func csvValue(v reflect.Value) string {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Int, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
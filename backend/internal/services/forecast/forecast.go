@@ -2,6 +2,7 @@ package forecast
 
 import (
 	"errors"
+	"math"
 	"time"
 
 	"github.com/google/uuid"
@@ -47,7 +48,7 @@ func ForecastCost(req ForecastRequest) (*ForecastResponse, error) {
 	start := end.Add(-30 * 24 * time.Hour)
 
 	var aggs []models.UsageAggregate
-	q := database.DB.
+	q := database.DB.Scopes(models.CanonicalAggregates).
 		Where("tenant_id = ? AND window_start >= ? AND window_end <= ?", tenantUUID, start, end)
 
 	if serviceUUID != nil {
@@ -65,6 +66,7 @@ func ForecastCost(req ForecastRequest) (*ForecastResponse, error) {
 				"gb_hours":     0,
 				"cold_starts":  0,
 				"egress_gb":    0,
+				"vcpu_hours":   0,
 			},
 		}, nil
 	}
@@ -72,13 +74,17 @@ func ForecastCost(req ForecastRequest) (*ForecastResponse, error) {
 	var totalInvocations int64
 	var totalMBHours float64
 	var totalColdStarts int64
+	var totalColdStartGBSeconds float64
 	var totalEgressBytes int64
+	var totalCPUMS int64
 
 	for _, a := range aggs {
 		totalInvocations += a.Invocations
 		totalMBHours += a.TotalMemoryMBHours
 		totalColdStarts += int64(a.ColdStarts)
+		totalColdStartGBSeconds += a.ColdStartGBSeconds
 		totalEgressBytes += a.EgressBytes
+		totalCPUMS += a.TotalCPUMS
 	}
 
 	avgDailyInvocations := float64(totalInvocations) / 30.0
@@ -90,15 +96,23 @@ func ForecastCost(req ForecastRequest) (*ForecastResponse, error) {
 	forecastMBHours := avgDailyMBHours * float64(days)
 	forecastGBHours := forecastMBHours / 1024.0 // MB*h -> GB*h
 	forecastColdStarts := avgDailyColdStarts * float64(days)
+	forecastColdStartGBSeconds := totalColdStartGBSeconds / 30.0 * float64(days)
 	forecastEgressGB := avgDailyEgressGB * float64(days)
+	forecastVCPUHours := float64(totalCPUMS) / 3_600_000.0 / 30.0 * float64(days)
 
 	var pricing models.PricingPlan
-	// лучше выбирать план по tenant_id (если есть), иначе общий (tenant_id IS NULL)
-	err = database.DB.
-		Where("tenant_id = ? AND active = true", tenantUUID).
-		Or("tenant_id IS NULL AND active = true").
-		Order("tenant_id DESC"). // tenant-specific будет "выше"
-		First(&pricing).Error
+	// план, назначенный арендатору, - тот же, по которому выставляется счёт
+	var tenant models.Tenant
+	if database.DB.First(&tenant, "id = ?", tenantUUID).Error == nil && tenant.PricingPlanID != nil {
+		err = database.DB.First(&pricing, "id = ?", *tenant.PricingPlanID).Error
+	} else {
+		// иначе tenant-specific, затем общий (tenant_id IS NULL)
+		err = database.DB.
+			Where("tenant_id = ? AND active = true", tenantUUID).
+			Or("tenant_id IS NULL AND active = true").
+			Order("tenant_id DESC"). // tenant-specific будет "выше"
+			First(&pricing).Error
+	}
 
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -107,12 +121,20 @@ func ForecastCost(req ForecastRequest) (*ForecastResponse, error) {
 		return nil, err
 	}
 
-	costInvocations := (forecastInvocations / 1_000_000.0) * pricing.PricePerMillionInvocations
-	costGBHours := forecastGBHours * pricing.PricePerGBHour
-	costColdStarts := forecastColdStarts * pricing.PricePerColdStart
-	costEgress := forecastEgressGB * pricing.PricePerGBEgress
-
-	totalCost := costInvocations + costGBHours + costColdStarts + costEgress
+	// как в счёте: каждая статья тарифицируется сверх бесплатного лимита
+	// (месячный лимит пропорционально периоду); к холодным стартам
+	// добавляется надбавка за инициализацию
+	free := func(monthly float64) float64 { return monthly * float64(days) / 30.0 }
+	freeInvocations := free(float64(pricing.FreeTierInvocations))
+	costInvocations := math.Max(0, forecastInvocations-freeInvocations) / 1_000_000.0 * pricing.PricePerMillionInvocations
+	costGBHours := math.Max(0, forecastGBHours-free(pricing.FreeTierGBHours)) * pricing.PricePerGBHour
+	freeColdStarts := free(float64(pricing.FreeTierColdStarts))
+	costColdStarts := math.Max(0, forecastColdStarts-freeColdStarts) * pricing.PricePerColdStart
+	costColdStarts += forecastColdStartGBSeconds * pricing.PricePerColdStartGBSecond
+	costEgress := math.Max(0, forecastEgressGB-free(pricing.FreeTierEgressGB)) * pricing.PricePerGBEgress
+	costCPU := math.Max(0, forecastVCPUHours-free(pricing.FreeTierVCPUHours)) * pricing.PricePerVCPUHour
+
+	totalCost := costInvocations + costGBHours + costColdStarts + costEgress + costCPU
 
 	return &ForecastResponse{
 		ForecastedCost: totalCost,
@@ -121,6 +143,7 @@ func ForecastCost(req ForecastRequest) (*ForecastResponse, error) {
 			"gb_hours":    costGBHours,
 			"cold_starts": costColdStarts,
 			"egress_gb":   costEgress,
+			"vcpu_hours":  costCPU,
 		},
 	}, nil
 }
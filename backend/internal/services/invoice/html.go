@@ -0,0 +1,16 @@
+package invoice
+
+import (
+	"embed"
+	"html/template"
+	"io"
+)
+
+//go:embed templates/*.tmpl
+var templatesFS embed.FS
+
+var htmlTemplate = template.Must(template.ParseFS(templatesFS, "templates/invoice.html.tmpl"))
+
+func RenderHTML(w io.Writer, doc *Document) error {
+	return htmlTemplate.Execute(w, doc)
+}
@@ -0,0 +1,264 @@
+package invoice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/lypolix/FaaS-billing/internal/i18n"
+	"github.com/lypolix/FaaS-billing/internal/models"
+)
+
+var ErrNotFinalized = errors.New("invoice is available only for finalized bills")
+
+// Document - счёт, подготовленный к выводу на конкретной локали:
+// все числа и даты уже отформатированы
+type Document struct {
+	Locale        string
+	InvoiceNumber string
+	Status        string
+	IssuedAt      string
+	PeriodStart   string
+	PeriodEnd     string
+
+	TenantName  string
+	TenantEmail string
+
+	Lines    []Line
+	FreeTier []FreeTierRow
+
+	Currency  string
+	Subtotal  string
+	TaxLabel  string // "НДС 20%", "Без НДС", ...
+	TaxAmount string
+	TaxNote   string // пояснение для exempt/reverse_charge и цен с налогом
+	Total     string
+
+	ExchangeNote string // курс пересчёта из валюты тарифа
+	BalanceNote  string // остаток кредитов после списания по счёту
+}
+
+type Line struct {
+	Description  string
+	Quantity     string
+	FreeTierUsed string
+	Billable     string
+	UnitPrice    string
+	Amount       string
+}
+
+type FreeTierRow struct {
+	Label string
+	Usage string
+}
+
+// Build собирает документ из финального (или оплаченного) счёта
+func Build(bill models.Bill, tenant models.Tenant, locale string) (*Document, error) {
+	if bill.Status != models.BillStatusFinal && bill.Status != models.BillStatusPaid {
+		return nil, ErrNotFinalized
+	}
+	locale = i18n.Normalize(locale)
+
+	items, freeTier, err := decodeLineItems(bill.LineItems)
+	if err != nil {
+		return nil, err
+	}
+
+	loc, err := time.LoadLocation(tenant.Timezone)
+	if err != nil || tenant.Timezone == "" {
+		loc = time.UTC
+	}
+
+	doc := &Document{
+		Locale:      locale,
+		Status:      i18n.T(locale, "status."+bill.Status),
+		PeriodStart: formatDate(locale, bill.PeriodStart.In(loc)),
+		PeriodEnd:   formatDate(locale, inclusiveEnd(bill.PeriodEnd.In(loc))),
+		TenantName:  tenant.Name,
+		TenantEmail: tenant.BillingEmail,
+		Currency:    bill.Currency,
+	}
+	if bill.InvoiceNumber != nil {
+		doc.InvoiceNumber = *bill.InvoiceNumber
+	}
+	if bill.FinalizedAt != nil {
+		doc.IssuedAt = formatDate(locale, bill.FinalizedAt.In(loc))
+	}
+
+	var itemsTotal float64
+	for _, it := range items {
+		description := i18n.LineItemLabel(locale, it.Code, it.AppliesTo, it.Description)
+		// тариф менялся внутри периода: указываем отрезок
+		if it.PeriodStart != nil && it.PeriodEnd != nil {
+			description += fmt.Sprintf(" (%s – %s)", formatDate(locale, it.PeriodStart.In(loc)),
+				formatDate(locale, inclusiveEnd(it.PeriodEnd.In(loc))))
+		}
+		doc.Lines = append(doc.Lines, Line{
+			Description:  description,
+			Quantity:     formatNumber(locale, it.Quantity, 4),
+			FreeTierUsed: formatNumber(locale, it.FreeTierUsed, 4),
+			Billable:     formatNumber(locale, it.BillableAmount, 4),
+			UnitPrice:    formatNumber(locale, it.UnitPrice, 4),
+			Amount:       formatNumber(locale, it.TotalCost, 2),
+		})
+		itemsTotal += it.TotalCost
+	}
+
+	// счета до появления налогов хранят только total_amount
+	subtotal := bill.Subtotal
+	if subtotal == 0 && bill.TaxAmount == 0 {
+		subtotal = itemsTotal
+	}
+	doc.Subtotal = formatNumber(locale, subtotal, 2)
+	doc.TaxAmount = formatNumber(locale, bill.TaxAmount, 2)
+	doc.Total = formatNumber(locale, bill.TotalAmount, 2)
+	doc.TaxLabel, doc.TaxNote = taxLabels(locale, bill)
+
+	doc.ExchangeNote = exchangeNote(locale, bill, items)
+
+	if bill.CreditsApplied > 0 || bill.BalanceRemaining > 0 {
+		doc.BalanceNote = fmt.Sprintf(i18n.T(locale, "invoice.balance_note"),
+			formatNumber(locale, bill.BalanceRemaining, 2), bill.Currency)
+	}
+
+	usage := func(used, limit float64, decimals int) string {
+		return fmt.Sprintf(i18n.T(locale, "invoice.used_of_limit"),
+			formatNumber(locale, used, decimals), formatNumber(locale, limit, decimals))
+	}
+	if freeTier != nil {
+		doc.FreeTier = []FreeTierRow{
+			{i18n.T(locale, "free_tier.invocations"), usage(float64(freeTier.InvocationsUsed), float64(freeTier.InvocationsLimit), 0)},
+			{i18n.T(locale, "free_tier.gb_hours"), usage(freeTier.GBHoursUsed, freeTier.GBHoursLimit, 4)},
+			{i18n.T(locale, "free_tier.egress_gb"), usage(freeTier.EgressGBUsed, freeTier.EgressGBLimit, 4)},
+		}
+		if freeTier.VCPUHoursLimit > 0 || freeTier.VCPUHoursUsed > 0 {
+			doc.FreeTier = append(doc.FreeTier, FreeTierRow{i18n.T(locale, "free_tier.vcpu_hours"),
+				usage(freeTier.VCPUHoursUsed, freeTier.VCPUHoursLimit, 4)})
+		}
+		if freeTier.ColdStartsLimit > 0 {
+			doc.FreeTier = append(doc.FreeTier, FreeTierRow{i18n.T(locale, "free_tier.cold_starts"),
+				usage(float64(freeTier.ColdStartsUsed), float64(freeTier.ColdStartsLimit), 0)})
+		}
+	}
+
+	return doc, nil
+}
+
+// exchangeNote перечисляет курсы пересчёта по отрезкам счёта; счета, у строк
+// которых курса нет, берут его из шапки
+func exchangeNote(locale string, bill models.Bill, items []models.BillingLineItem) string {
+	var notes []string
+	seen := make(map[string]bool)
+	add := func(source string, rate float64, date *time.Time) {
+		if source == "" || date == nil {
+			return
+		}
+		note := fmt.Sprintf(i18n.T(locale, "invoice.exchange_note"),
+			source, formatNumber(locale, rate, 6), bill.Currency, formatDate(locale, *date))
+		if !seen[note] {
+			seen[note] = true
+			notes = append(notes, note)
+		}
+	}
+	for _, it := range items {
+		add(it.SourceCurrency, it.ExchangeRate, it.ExchangeRateDate)
+	}
+	if len(notes) == 0 {
+		add(bill.SourceCurrency, bill.ExchangeRate, bill.ExchangeRateDate)
+	}
+	return strings.Join(notes, "; ")
+}
+
+func taxLabels(locale string, bill models.Bill) (label, note string) {
+	switch bill.TaxMode {
+	case models.TaxModeStandard:
+		label = fmt.Sprintf("%s %s%%", i18n.T(locale, "invoice.tax"), formatNumber(locale, bill.TaxRate*100, 4))
+		if bill.TaxInclusive {
+			note = i18n.T(locale, "tax.inclusive_note")
+		}
+	case models.TaxModeExempt:
+		label = i18n.T(locale, "tax.exempt")
+		note = i18n.T(locale, "tax.exempt_note")
+	case models.TaxModeReverseCharge:
+		label = i18n.T(locale, "tax.reverse_charge")
+		note = i18n.T(locale, "tax.reverse_charge_note")
+	default:
+		label = i18n.T(locale, "tax.none")
+	}
+	return label, note
+}
+
+// T - перевод подписи на локали документа (используется шаблонами)
+func (d *Document) T(key string) string {
+	return i18n.T(d.Locale, key)
+}
+
+func decodeLineItems(j models.JSONB) ([]models.BillingLineItem, *models.FreeTierSummary, error) {
+	var payload struct {
+		Items    []models.BillingLineItem `json:"items"`
+		FreeTier *models.FreeTierSummary  `json:"free_tier"`
+	}
+	b, err := json.Marshal(j)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := json.Unmarshal(b, &payload); err != nil {
+		return nil, nil, fmt.Errorf("invalid bill line items: %w", err)
+	}
+	return payload.Items, payload.FreeTier, nil
+}
+
+// Период счёта хранится полуинтервалом [start, end); в документе
+// показываем последний день периода
+func inclusiveEnd(t time.Time) time.Time {
+	if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0 {
+		return t.Add(-time.Nanosecond)
+	}
+	return t
+}
+
+func formatDate(locale string, t time.Time) string {
+	if locale == i18n.EN {
+		return t.Format("Jan 2, 2006")
+	}
+	return t.Format("02.01.2006")
+}
+
+// formatNumber: ru - "1 234,56", en - "1,234.56"; хвостовые нули
+// дробной части у количеств отбрасываются
+func formatNumber(locale string, v float64, decimals int) string {
+	thousands, point := ",", "."
+	if locale != i18n.EN {
+		thousands, point = " ", ","
+	}
+
+	neg := v < 0
+	v = math.Abs(v)
+	s := fmt.Sprintf("%.*f", decimals, v)
+	intPart, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, frac = s[:i], s[i+1:]
+	}
+	if decimals > 2 {
+		frac = strings.TrimRight(frac, "0")
+	}
+
+	var b strings.Builder
+	if neg {
+		b.WriteByte('-')
+	}
+	for i, r := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteString(thousands)
+		}
+		b.WriteRune(r)
+	}
+	if frac != "" {
+		b.WriteString(point)
+		b.WriteString(frac)
+	}
+	return b.String()
+}
@@ -0,0 +1,138 @@
+package invoice
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-pdf/fpdf"
+
+	"github.com/lypolix/FaaS-billing/internal/i18n"
+)
+
+var ErrFontUnavailable = errors.New("invoice font with cyrillic glyphs is not available, set INVOICE_FONT_PATH")
+
+const defaultFontPath = "/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf"
+
+// RenderPDF выводит документ в PDF. Для кириллицы нужен TTF-шрифт
+// (INVOICE_FONT_PATH, по умолчанию DejaVu Sans); без него доступен
+// только английский счёт встроенным Helvetica.
+func RenderPDF(w io.Writer, doc *Document) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(15, 15, 15)
+	pdf.AddPage()
+
+	family, tr, err := setupFont(pdf, doc.Locale)
+	if err != nil {
+		return err
+	}
+
+	pdf.SetFont(family, "", 18)
+	pdf.CellFormat(0, 10, tr(doc.T("invoice.title")+" "+doc.InvoiceNumber), "", 1, "L", false, 0, "")
+	pdf.Ln(2)
+
+	pdf.SetFont(family, "", 10)
+	meta := [][2]string{
+		{doc.T("invoice.status"), doc.Status},
+		{doc.T("invoice.issued"), doc.IssuedAt},
+		{doc.T("invoice.period"), doc.PeriodStart + " - " + doc.PeriodEnd},
+		{doc.T("invoice.bill_to"), doc.TenantName},
+	}
+	if doc.TenantEmail != "" {
+		meta = append(meta, [2]string{doc.T("invoice.email"), doc.TenantEmail})
+	}
+	for _, row := range meta {
+		pdf.SetTextColor(100, 100, 100)
+		pdf.CellFormat(45, 6, tr(row[0]), "", 0, "L", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+		pdf.CellFormat(0, 6, tr(row[1]), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(4)
+
+	widths := []float64{60, 24, 24, 24, 24, 24}
+	headers := []string{
+		doc.T("invoice.description"),
+		doc.T("invoice.quantity"),
+		doc.T("invoice.free_tier_used"),
+		doc.T("invoice.billable"),
+		doc.T("invoice.unit_price"),
+		doc.T("invoice.amount") + ", " + doc.Currency,
+	}
+	pdf.SetFont(family, "", 8)
+	pdf.SetFillColor(235, 235, 235)
+	for i, h := range headers {
+		pdf.CellFormat(widths[i], 7, tr(h), "B", 0, align(i), true, 0, "")
+	}
+	pdf.Ln(-1)
+
+	pdf.SetFont(family, "", 9)
+	for _, l := range doc.Lines {
+		cells := []string{l.Description, l.Quantity, l.FreeTierUsed, l.Billable, l.UnitPrice, l.Amount}
+		for i, v := range cells {
+			pdf.CellFormat(widths[i], 7, tr(v), "B", 0, align(i), false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+
+	pdf.Ln(2)
+	labelWidth := widths[0] + widths[1] + widths[2] + widths[3] + widths[4]
+	totals := [][2]string{
+		{doc.T("invoice.subtotal"), doc.Subtotal + " " + doc.Currency},
+		{doc.TaxLabel, doc.TaxAmount + " " + doc.Currency},
+		{doc.T("invoice.total"), doc.Total + " " + doc.Currency},
+	}
+	pdf.SetFont(family, "", 10)
+	for _, row := range totals {
+		pdf.CellFormat(labelWidth, 7, tr(row[0]), "", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[5], 7, tr(row[1]), "", 1, "R", false, 0, "")
+	}
+
+	for _, note := range []string{doc.TaxNote, doc.ExchangeNote, doc.BalanceNote} {
+		if note == "" {
+			continue
+		}
+		pdf.SetFont(family, "", 8)
+		pdf.SetTextColor(100, 100, 100)
+		pdf.CellFormat(0, 6, tr(note), "", 1, "L", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+	}
+
+	if len(doc.FreeTier) > 0 {
+		pdf.Ln(6)
+		pdf.SetFont(family, "", 11)
+		pdf.CellFormat(0, 7, tr(doc.T("invoice.free_tier")), "", 1, "L", false, 0, "")
+		pdf.SetFont(family, "", 9)
+		for _, row := range doc.FreeTier {
+			pdf.CellFormat(60, 6, tr(row.Label), "", 0, "L", false, 0, "")
+			pdf.CellFormat(0, 6, tr(row.Usage), "", 1, "L", false, 0, "")
+		}
+	}
+
+	if err := pdf.Error(); err != nil {
+		return fmt.Errorf("render pdf: %w", err)
+	}
+	return pdf.Output(w)
+}
+
+func setupFont(pdf *fpdf.Fpdf, locale string) (string, func(string) string, error) {
+	path := os.Getenv("INVOICE_FONT_PATH")
+	if path == "" {
+		path = defaultFontPath
+	}
+	if b, err := os.ReadFile(path); err == nil {
+		pdf.AddUTF8FontFromBytes("invoice", "", b)
+		return "invoice", func(s string) string { return s }, nil
+	}
+	if locale != i18n.EN {
+		return "", nil, ErrFontUnavailable
+	}
+	return "Helvetica", pdf.UnicodeTranslatorFromDescriptor(""), nil
+}
+
+func align(col int) string {
+	if col == 0 {
+		return "L"
+	}
+	return "R"
+}
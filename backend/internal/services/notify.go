@@ -0,0 +1,162 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lypolix/FaaS-billing/internal/models"
+)
+
+// Каналы уведомлений
+const (
+	ChannelLog     = "log"
+	ChannelWebhook = "webhook"
+	ChannelEmail   = "email"
+)
+
+var ErrNotifierNotConfigured = errors.New("notification channel is not configured")
+
+// BudgetNotification - уведомление о сработавшем пороге бюджета
+type BudgetNotification struct {
+	BudgetID    uuid.UUID  `json:"budget_id"`
+	BudgetName  string     `json:"budget_name"`
+	TenantID    uuid.UUID  `json:"tenant_id"`
+	TenantName  string     `json:"tenant_name"`
+	ServiceID   *uuid.UUID `json:"service_id,omitempty"`
+	Kind        string     `json:"kind"`      // actual | forecast
+	Threshold   int        `json:"threshold"` // процент
+	Amount      float64    `json:"amount"`    // расходы или прогноз
+	Budget      float64    `json:"budget"`
+	Currency    string     `json:"currency"`
+	PeriodStart time.Time  `json:"period_start"`
+	PeriodEnd   time.Time  `json:"period_end"`
+
+	// адресаты, которые зависят от арендатора и бюджета
+	Email      string `json:"-"`
+	WebhookURL string `json:"-"`
+}
+
+func (n BudgetNotification) Subject() string {
+	what := "spend"
+	if n.Kind == models.BudgetAlertForecast {
+		what = "forecasted spend"
+	}
+	return fmt.Sprintf("Budget %q: %s reached %d%% (%.2f of %.2f %s)",
+		n.BudgetName, what, n.Threshold, n.Amount, n.Budget, n.Currency)
+}
+
+// Notifier - канал доставки уведомлений о бюджетах
+type Notifier interface {
+	Name() string
+	Notify(n BudgetNotification) error
+}
+
+// LogNotifier пишет уведомление в лог процесса
+type LogNotifier struct{}
+
+func (LogNotifier) Name() string { return ChannelLog }
+
+func (LogNotifier) Notify(n BudgetNotification) error {
+	log.Printf("budget alert: tenant=%s budget=%s %s", n.TenantID, n.BudgetID, n.Subject())
+	return nil
+}
+
+// WebhookNotifier отправляет уведомление POST-запросом с JSON. URL берётся
+// из бюджета, иначе используется DefaultURL.
+type WebhookNotifier struct {
+	DefaultURL string
+	Client     *http.Client
+}
+
+func (w WebhookNotifier) Name() string { return ChannelWebhook }
+
+func (w WebhookNotifier) Notify(n BudgetNotification) error {
+	url := n.WebhookURL
+	if url == "" {
+		url = w.DefaultURL
+	}
+	if url == "" {
+		return ErrNotifierNotConfigured
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// SMTPNotifier отправляет письмо на BillingEmail арендатора
+type SMTPNotifier struct {
+	Addr     string // host:port
+	From     string
+	Username string
+	Password string
+}
+
+func (m SMTPNotifier) Name() string { return ChannelEmail }
+
+func (m SMTPNotifier) Notify(n BudgetNotification) error {
+	if m.Addr == "" || m.From == "" {
+		return ErrNotifierNotConfigured
+	}
+	if n.Email == "" {
+		return fmt.Errorf("tenant %s has no billing email", n.TenantID)
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		host := m.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", m.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", n.Email)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", n.Subject())
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	fmt.Fprintf(&msg, "Tenant: %s\r\n", n.TenantName)
+	fmt.Fprintf(&msg, "Period: %s - %s\r\n", n.PeriodStart.Format("2006-01-02"), n.PeriodEnd.Format("2006-01-02"))
+	fmt.Fprintf(&msg, "%s\r\n", n.Subject())
+
+	return smtp.SendMail(m.Addr, auth, m.From, []string{n.Email}, []byte(msg.String()))
+}
+
+// NotifiersFromEnv собирает каналы: log всегда, webhook с адресом по
+// умолчанию BUDGET_WEBHOOK_URL, email через SMTP_ADDR/SMTP_FROM
+// (SMTP_USERNAME/SMTP_PASSWORD - при необходимости авторизации)
+func NotifiersFromEnv() []Notifier {
+	return []Notifier{
+		LogNotifier{},
+		WebhookNotifier{DefaultURL: os.Getenv("BUDGET_WEBHOOK_URL")},
+		SMTPNotifier{
+			Addr:     os.Getenv("SMTP_ADDR"),
+			From:     os.Getenv("SMTP_FROM"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		},
+	}
+}
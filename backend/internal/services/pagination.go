@@ -0,0 +1,169 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Размер страницы списков
+const (
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+)
+
+var ErrInvalidPage = errors.New("invalid pagination parameters")
+
+// SortField - колонка, по которой разрешено сортировать список
+type SortField struct {
+	Column string
+	Time   bool // значение - время; иначе строка
+}
+
+// ListSpec описывает сортировки списка. Страницы строятся по ключу
+// (колонка сортировки, id), поэтому вставки не сдвигают страницы.
+type ListSpec struct {
+	Sorts       map[string]SortField // имя в параметре sort -> колонка
+	DefaultSort string               // например "-window_start" (по убыванию)
+	NumericID   bool                 // id - целое (usage_aggregates), иначе uuid
+}
+
+// PageRequest - параметры limit, sort и cursor из запроса
+type PageRequest struct {
+	Limit  int
+	Sort   string
+	Cursor string
+}
+
+// Page - конверт ответа списка. NextCursor пуст на последней странице.
+type Page[T any] struct {
+	Data       []T    `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// Paginate выбирает страницу q. key возвращает значение колонки сортировки
+// (time.Time или string) и id строки - из них строится next_cursor.
+func Paginate[T any](q *gorm.DB, req PageRequest, spec ListSpec, key func(row T, sort string) (interface{}, interface{})) (*Page[T], error) {
+	limit := req.Limit
+	if limit == 0 {
+		limit = DefaultPageLimit
+	}
+	if limit < 0 || limit > MaxPageLimit {
+		return nil, fmt.Errorf("%w: limit must be in 1..%d", ErrInvalidPage, MaxPageLimit)
+	}
+
+	sort := req.Sort
+	if sort == "" {
+		sort = spec.DefaultSort
+	}
+	name := strings.TrimPrefix(sort, "-")
+	desc := name != sort
+	field, ok := spec.Sorts[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported sort %q", ErrInvalidPage, name)
+	}
+	dir, op := "ASC", ">"
+	if desc {
+		dir, op = "DESC", "<"
+	}
+
+	if req.Cursor != "" {
+		value, id, err := decodeCursor(req.Cursor, sort, field, spec.NumericID)
+		if err != nil {
+			return nil, err
+		}
+		q = q.Where(fmt.Sprintf("(%s, id) %s (?, ?)", field.Column, op), value, id)
+	}
+
+	var rows []T
+	if err := q.Order(field.Column + " " + dir + ", id " + dir).Limit(limit + 1).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	page := &Page[T]{Data: rows}
+	if len(rows) > limit {
+		page.Data = rows[:limit]
+		value, id := key(rows[limit-1], name)
+		page.NextCursor = encodeCursor(sort, value, id)
+	}
+	if page.Data == nil {
+		page.Data = []T{}
+	}
+	return page, nil
+}
+
+func encodeCursor(sort string, value, id interface{}) string {
+	c := pageCursor{Sort: sort, ID: fmt.Sprint(id)}
+	if t, ok := value.(time.Time); ok {
+		c.Value = t.UTC().Format(time.RFC3339Nano)
+	} else {
+		c.Value = fmt.Sprint(value)
+	}
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s, sort string, field SortField, numericID bool) (interface{}, interface{}, error) {
+	invalid := fmt.Errorf("%w: malformed cursor", ErrInvalidPage)
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, nil, invalid
+	}
+	var c pageCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, nil, invalid
+	}
+	if c.Sort != sort {
+		return nil, nil, fmt.Errorf("%w: cursor was issued for sort %q", ErrInvalidPage, c.Sort)
+	}
+
+	var value interface{} = c.Value
+	if field.Time {
+		t, err := time.Parse(time.RFC3339Nano, c.Value)
+		if err != nil {
+			return nil, nil, invalid
+		}
+		value = t
+	}
+	var id interface{} = c.ID
+	if numericID {
+		n, err := strconv.ParseInt(c.ID, 10, 64)
+		if err != nil {
+			return nil, nil, invalid
+		}
+		id = n
+	}
+	return value, id, nil
+}
+
+// Сортировки списков, которые строятся в хендлерах
+
+var TenantListSpec = ListSpec{
+	Sorts: map[string]SortField{
+		"created_at": {Column: "created_at", Time: true},
+		"name":       {Column: "name"},
+	},
+	DefaultSort: "created_at",
+}
+
+var ServiceListSpec = TenantListSpec
+
+var UsageAggregateListSpec = ListSpec{
+	Sorts: map[string]SortField{
+		"window_start": {Column: "window_start", Time: true},
+	},
+	DefaultSort: "-window_start",
+	NumericID:   true,
+}
@@ -0,0 +1,449 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lypolix/FaaS-billing/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrPlanNotFound     = errors.New("pricing plan not found")
+	ErrPlanArchived     = errors.New("pricing plan is archived")
+	ErrInvalidPlanInput = errors.New("invalid pricing plan")
+	ErrAssignmentOrder  = errors.New("plan assignment must start after the current one")
+	ErrPlanInactive     = errors.New("pricing plan is inactive")
+	ErrPlanPrivate      = errors.New("pricing plan is private to another tenant")
+	ErrPlanCurrency     = errors.New("pricing plan currency is incompatible with tenant")
+	ErrNoPlanAssigned   = errors.New("tenant has no pricing plan assigned")
+)
+
+// PlanService управляет тарифными планами и их версиями, а также
+// историей назначений планов арендаторам
+type PlanService struct {
+	db *gorm.DB
+}
+
+func NewPlanService(db *gorm.DB) *PlanService {
+	return &PlanService{db: db}
+}
+
+var planListSpec = ListSpec{
+	Sorts: map[string]SortField{
+		"created_at": {Column: "created_at", Time: true},
+		"name":       {Column: "name"},
+	},
+	DefaultSort: "-created_at",
+}
+
+// ListPlans возвращает страницу последних версий планов; allVersions - все версии
+func (s *PlanService) ListPlans(activeOnly, allVersions bool, page PageRequest) (*Page[models.PricingPlan], error) {
+	q := s.db.Model(&models.PricingPlan{})
+	if activeOnly {
+		q = q.Where("active = ?", true)
+	}
+	if !allVersions {
+		q = q.Where("version = (SELECT MAX(p2.version) FROM pricing_plans p2 WHERE p2.group_id = pricing_plans.group_id)")
+	}
+	return Paginate(q, page, planListSpec, func(p models.PricingPlan, sort string) (interface{}, interface{}) {
+		if sort == "name" {
+			return p.Name, p.ID
+		}
+		return p.CreatedAt, p.ID
+	})
+}
+
+func (s *PlanService) GetPlan(id uuid.UUID) (*models.PricingPlan, error) {
+	var plan models.PricingPlan
+	if err := s.db.First(&plan, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPlanNotFound
+		}
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// Versions - все версии семейства плана по id любой из версий
+func (s *PlanService) Versions(id uuid.UUID) ([]models.PricingPlan, error) {
+	plan, err := s.GetPlan(id)
+	if err != nil {
+		return nil, err
+	}
+	return planVersions(s.db, plan.GroupID)
+}
+
+// CreatePlan создаёт первую версию нового плана
+func (s *PlanService) CreatePlan(plan models.PricingPlan) (*models.PricingPlan, error) {
+	if err := validatePlan(plan); err != nil {
+		return nil, err
+	}
+	plan.ID = uuid.New()
+	plan.GroupID = plan.ID
+	plan.Version = 1
+	plan.Currency = normalizeCurrency(plan.Currency)
+	if plan.EffectiveFrom.IsZero() {
+		plan.EffectiveFrom = time.Now()
+	}
+	plan.ArchivedAt = nil
+	plan.Active = true
+
+	if err := s.db.Create(&plan).Error; err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// UpdatePlan создаёт новую версию плана. Прежние версии не меняются и
+// продолжают применяться к периодам до effective_from новой версии.
+func (s *PlanService) UpdatePlan(id uuid.UUID, changes models.PricingPlan) (*models.PricingPlan, error) {
+	if err := validatePlan(changes); err != nil {
+		return nil, err
+	}
+
+	var out models.PricingPlan
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var base models.PricingPlan
+		if err := tx.First(&base, "id = ?", id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrPlanNotFound
+			}
+			return err
+		}
+
+		var latest models.PricingPlan
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("group_id = ?", base.GroupID).
+			Order("version DESC").
+			First(&latest).Error; err != nil {
+			return err
+		}
+		if latest.ArchivedAt != nil {
+			return ErrPlanArchived
+		}
+
+		effectiveFrom := changes.EffectiveFrom
+		if effectiveFrom.IsZero() {
+			effectiveFrom = time.Now()
+		}
+		if !effectiveFrom.After(latest.EffectiveFrom) {
+			return fmt.Errorf("%w: effective_from must be after %s (version %d)",
+				ErrInvalidPlanInput, latest.EffectiveFrom.Format(time.RFC3339), latest.Version)
+		}
+		if changes.Currency != "" && normalizeCurrency(changes.Currency) != normalizeCurrency(latest.Currency) {
+			return fmt.Errorf("%w: currency cannot change between versions", ErrInvalidPlanInput)
+		}
+
+		out = changes
+		out.ID = uuid.New()
+		out.GroupID = latest.GroupID
+		out.Version = latest.Version + 1
+		out.TenantID = latest.TenantID
+		out.Currency = normalizeCurrency(latest.Currency)
+		out.EffectiveFrom = effectiveFrom
+		out.ArchivedAt = nil
+		out.Active = true
+		out.CreatedAt = time.Time{}
+		if out.Name == "" {
+			out.Name = latest.Name
+		}
+		if err := tx.Create(&out).Error; err != nil {
+			return err
+		}
+
+		// у арендаторов сразу отображается действующая версия
+		if !effectiveFrom.After(time.Now()) {
+			return tx.Model(&models.Tenant{}).
+				Where("pricing_plan_id IN (SELECT id FROM pricing_plans WHERE group_id = ?)", out.GroupID).
+				Update("pricing_plan_id", out.ID).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ArchivePlan закрывает план для новых назначений и правок. Уже
+// назначенные арендаторы продолжают тарифицироваться по нему.
+func (s *PlanService) ArchivePlan(id uuid.UUID) (*models.PricingPlan, error) {
+	plan, err := s.GetPlan(id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if err := s.db.Model(&models.PricingPlan{}).
+		Where("group_id = ? AND archived_at IS NULL", plan.GroupID).
+		Updates(map[string]interface{}{"archived_at": now, "active": false}).Error; err != nil {
+		return nil, err
+	}
+	return s.GetPlan(id)
+}
+
+// AssignPlan назначает арендатору семейство плана с даты effectiveFrom
+// (по умолчанию - сейчас). Предыдущее назначение закрывается этой датой.
+func (s *PlanService) AssignPlan(tenantID, planID uuid.UUID, effectiveFrom time.Time) (*models.TenantPlanAssignment, error) {
+	if effectiveFrom.IsZero() {
+		effectiveFrom = time.Now()
+	}
+
+	var out models.TenantPlanAssignment
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var tenant models.Tenant
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&tenant, "id = ?", tenantID).Error; err != nil {
+			return fmt.Errorf("tenant not found: %w", err)
+		}
+		var plan models.PricingPlan
+		if err := tx.First(&plan, "id = ?", planID).Error; err != nil {
+			return ErrPlanNotFound
+		}
+		if err := checkAssignable(tx, tenant, plan); err != nil {
+			return err
+		}
+
+		var current models.TenantPlanAssignment
+		err := tx.Where("tenant_id = ? AND effective_to IS NULL", tenantID).
+			Order("effective_from DESC").
+			First(&current).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+		case err != nil:
+			return err
+		default:
+			if !effectiveFrom.After(current.EffectiveFrom) {
+				return fmt.Errorf("%w (%s)", ErrAssignmentOrder, current.EffectiveFrom.Format(time.RFC3339))
+			}
+			if err := tx.Model(&models.TenantPlanAssignment{}).
+				Where("id = ?", current.ID).
+				Update("effective_to", effectiveFrom).Error; err != nil {
+				return err
+			}
+		}
+
+		out = models.TenantPlanAssignment{
+			ID:            uuid.New(),
+			TenantID:      tenantID,
+			PlanGroupID:   plan.GroupID,
+			EffectiveFrom: effectiveFrom,
+		}
+		if err := tx.Create(&out).Error; err != nil {
+			return err
+		}
+
+		// Tenant.PricingPlanID показывает текущую версию назначенного плана;
+		// назначение с будущей даты его не меняет
+		now := time.Now()
+		if effectiveFrom.After(now) {
+			return nil
+		}
+		versions, err := planVersions(tx, plan.GroupID)
+		if err != nil {
+			return err
+		}
+		v := versionAt(versions, now)
+		return tx.Model(&models.Tenant{}).Where("id = ?", tenantID).Update("pricing_plan_id", v.ID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Assignments - история назначений планов арендатору
+func (s *PlanService) Assignments(tenantID uuid.UUID) ([]models.TenantPlanAssignment, error) {
+	var out []models.TenantPlanAssignment
+	if err := s.db.Where("tenant_id = ?", tenantID).Order("effective_from").Find(&out).Error; err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CurrentPlan - версия плана, действующая для арендатора в момент at
+func (s *PlanService) CurrentPlan(tenantID uuid.UUID, at time.Time) (*models.PricingPlan, error) {
+	var tenant models.Tenant
+	if err := s.db.First(&tenant, "id = ?", tenantID).Error; err != nil {
+		return nil, fmt.Errorf("tenant not found: %w", err)
+	}
+	segments, err := planSegments(s.db, tenant, at, at.Add(time.Nanosecond))
+	if err != nil {
+		return nil, err
+	}
+	return &segments[0].Plan, nil
+}
+
+// AvailablePlans - последние версии планов, которые можно назначить арендатору
+func (s *PlanService) AvailablePlans(tenantID uuid.UUID) ([]models.PricingPlan, error) {
+	var tenant models.Tenant
+	if err := s.db.First(&tenant, "id = ?", tenantID).Error; err != nil {
+		return nil, fmt.Errorf("tenant not found: %w", err)
+	}
+	var plans []models.PricingPlan
+	if err := s.db.Where("active = ? AND archived_at IS NULL", true).
+		Where("tenant_id IS NULL OR tenant_id = ?", tenantID).
+		Where("version = (SELECT MAX(p2.version) FROM pricing_plans p2 WHERE p2.group_id = pricing_plans.group_id)").
+		Order("tenant_id NULLS LAST, name").
+		Find(&plans).Error; err != nil {
+		return nil, err
+	}
+
+	out := make([]models.PricingPlan, 0, len(plans))
+	for _, p := range plans {
+		err := checkAssignable(s.db, tenant, p)
+		switch {
+		case errors.Is(err, ErrPlanCurrency):
+		case err != nil:
+			return nil, err
+		default:
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+// checkAssignable проверяет правила назначения: приватный план - только
+// своему арендатору, план активен и не в архиве, счёт можно выставить в
+// валюте арендатора (та же валюта или известен курс)
+func checkAssignable(db *gorm.DB, tenant models.Tenant, plan models.PricingPlan) error {
+	if plan.TenantID != nil && *plan.TenantID != tenant.ID {
+		return ErrPlanPrivate
+	}
+	if plan.ArchivedAt != nil {
+		return ErrPlanArchived
+	}
+	if !plan.Active {
+		return ErrPlanInactive
+	}
+	_, _, err := NewCurrencyService(db).Rate(plan.Currency, tenant.Currency, time.Now())
+	switch {
+	case errors.Is(err, ErrNoExchangeRate):
+		return fmt.Errorf("%w: %v", ErrPlanCurrency, err)
+	case err != nil:
+		return fmt.Errorf("exchange rate lookup: %w", err)
+	}
+	return nil
+}
+
+func validatePlan(p models.PricingPlan) error {
+	prices := []float64{
+		p.PricePerMillionInvocations, p.PricePerGBHour, p.PricePerColdStart, p.PricePerGBEgress,
+		p.PricePerGBHourProvisioned, p.PricePerGBHourActive, p.FreeTierGBHours, p.FreeTierEgressGB,
+		p.PricePerColdStartGBSecond, p.PricePerVCPUHour, p.FreeTierVCPUHours,
+	}
+	for _, v := range prices {
+		if v < 0 {
+			return fmt.Errorf("%w: prices and free tier must not be negative", ErrInvalidPlanInput)
+		}
+	}
+	if p.FreeTierInvocations < 0 || p.FreeTierColdStarts < 0 {
+		return fmt.Errorf("%w: prices and free tier must not be negative", ErrInvalidPlanInput)
+	}
+	switch p.MemoryBillingMode {
+	case "", models.MemoryBillingMeasured, models.MemoryBillingAllocated:
+	default:
+		return fmt.Errorf("%w: memory_billing_mode must be measured or allocated", ErrInvalidPlanInput)
+	}
+	if p.MinBillableDurationMS < 0 || p.DurationRoundingMS < 0 {
+		return fmt.Errorf("%w: duration rules must not be negative", ErrInvalidPlanInput)
+	}
+	return nil
+}
+
+func planVersions(db *gorm.DB, groupID uuid.UUID) ([]models.PricingPlan, error) {
+	var versions []models.PricingPlan
+	if err := db.Where("group_id = ?", groupID).Order("version").Find(&versions).Error; err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, ErrPlanNotFound
+	}
+	return versions, nil
+}
+
+// versionAt - версия, действующая в момент at. До вступления в силу первой
+// версии применяется она же.
+func versionAt(versions []models.PricingPlan, at time.Time) models.PricingPlan {
+	v := versions[0]
+	for _, p := range versions {
+		if !p.EffectiveFrom.After(at) {
+			v = p
+		}
+	}
+	return v
+}
+
+// Отрезок периода счёта, тарифицируемый одной версией плана
+type planSegment struct {
+	Start, End time.Time
+	Plan       models.PricingPlan
+}
+
+// planSegments делит период на отрезки по границам смены назначенного плана
+// и вступления в силу его версий. Использование до первого назначения
+// тарифицируется первым назначенным планом.
+func planSegments(db *gorm.DB, tenant models.Tenant, start, end time.Time) ([]planSegment, error) {
+	var assignments []models.TenantPlanAssignment
+	if err := db.Where("tenant_id = ? AND effective_from < ?", tenant.ID, end).
+		Where("effective_to IS NULL OR effective_to > ?", start).
+		Order("effective_from").
+		Find(&assignments).Error; err != nil {
+		return nil, fmt.Errorf("failed to load plan assignments: %w", err)
+	}
+
+	// арендаторы, назначенные до появления истории
+	if len(assignments) == 0 {
+		if tenant.PricingPlanID == nil {
+			return nil, ErrNoPlanAssigned
+		}
+		var plan models.PricingPlan
+		if err := db.First(&plan, "id = ?", *tenant.PricingPlanID).Error; err != nil {
+			return nil, fmt.Errorf("pricing plan not found: %w", err)
+		}
+		assignments = []models.TenantPlanAssignment{{PlanGroupID: plan.GroupID, EffectiveFrom: start}}
+	}
+
+	var segments []planSegment
+	for i, a := range assignments {
+		from, to := a.EffectiveFrom, end
+		if i == 0 || from.Before(start) {
+			from = start
+		}
+		if a.EffectiveTo != nil && a.EffectiveTo.Before(to) {
+			to = *a.EffectiveTo
+		}
+		if !from.Before(to) {
+			continue
+		}
+
+		versions, err := planVersions(db, a.PlanGroupID)
+		if err != nil {
+			return nil, err
+		}
+		bounds := []time.Time{from}
+		for _, v := range versions {
+			if v.EffectiveFrom.After(from) && v.EffectiveFrom.Before(to) {
+				bounds = append(bounds, v.EffectiveFrom)
+			}
+		}
+		sort.Slice(bounds, func(i, j int) bool { return bounds[i].Before(bounds[j]) })
+		bounds = append(bounds, to)
+
+		for j := 0; j < len(bounds)-1; j++ {
+			plan := versionAt(versions, bounds[j])
+			if n := len(segments); n > 0 && segments[n-1].Plan.ID == plan.ID && segments[n-1].End.Equal(bounds[j]) {
+				segments[n-1].End = bounds[j+1]
+				continue
+			}
+			segments = append(segments, planSegment{Start: bounds[j], End: bounds[j+1], Plan: plan})
+		}
+	}
+	if len(segments) == 0 {
+		return nil, ErrNoPlanAssigned
+	}
+	return segments, nil
+}
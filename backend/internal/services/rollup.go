@@ -0,0 +1,239 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lypolix/FaaS-billing/internal/models"
+	"github.com/lypolix/FaaS-billing/internal/services/aggregation"
+	"gorm.io/gorm"
+)
+
+// RollupLevel - уровень свёртки: окна Size собираются из окон Source
+type RollupLevel struct {
+	Size   string
+	Source string
+}
+
+// DefaultRollups - иерархия 1m -> 1h -> 1d
+var DefaultRollups = []RollupLevel{
+	{Size: "1h", Source: "1m"},
+	{Size: "1d", Source: "1h"},
+}
+
+type RollupService struct {
+	db *gorm.DB
+}
+
+func NewRollupService(db *gorm.DB) *RollupService {
+	return &RollupService{db: db}
+}
+
+type RollupOptions struct {
+	Now   time.Time
+	Grace time.Duration // окно сворачивается не раньше, чем через Grace после конца
+	Force bool          // сворачивать, даже если не все окна-источники отмечены
+}
+
+// Rollup сворачивает завершённые окна уровня, ещё не свёрнутые ранее, и
+// возвращает их число. Окно сворачивается, только когда все его окна-
+// источники отмечены завершёнными (или Force): частичная свёртка заменила
+// бы собой ещё не посчитанное использование.
+func (s *RollupService) Rollup(level RollupLevel, opts RollupOptions) (int, error) {
+	size, err := aggregation.ParseWindowSize(level.Size)
+	if err != nil {
+		return 0, err
+	}
+	src, err := aggregation.ParseWindowSize(level.Source)
+	if err != nil {
+		return 0, err
+	}
+	if size <= src || size%src != 0 {
+		return 0, fmt.Errorf("window %s is not a multiple of %s", level.Size, level.Source)
+	}
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+
+	// первое окно-источник, ещё не покрытое свёрткой этого уровня
+	var first struct{ Start *time.Time }
+	if err := s.db.Raw(`
+		SELECT MIN(m.window_start) AS start FROM aggregation_windows m
+		WHERE m.window_size = ? AND NOT EXISTS (
+			SELECT 1 FROM aggregation_windows r
+			WHERE r.window_size = ? AND r.window_start <= m.window_start AND r.window_end >= m.window_end
+		)`, level.Source, level.Size).Scan(&first).Error; err != nil {
+		return 0, err
+	}
+	if first.Start == nil {
+		return 0, nil
+	}
+
+	last := opts.Now.Add(-opts.Grace).UTC().Truncate(size)
+	done, waiting := 0, 0
+	for start := first.Start.UTC().Truncate(size); start.Before(last); start = start.Add(size) {
+		end := start.Add(size)
+
+		var rolled int64
+		if err := s.db.Model(&models.AggregationWindow{}).
+			Where("window_size = ? AND window_start = ?", level.Size, start).
+			Count(&rolled).Error; err != nil {
+			return done, err
+		}
+		if rolled > 0 {
+			continue
+		}
+
+		if !opts.Force {
+			var complete int64
+			if err := s.db.Model(&models.AggregationWindow{}).
+				Where("window_size = ? AND window_start >= ? AND window_start < ?", level.Source, start, end).
+				Count(&complete).Error; err != nil {
+				return done, err
+			}
+			if complete < int64(size/src) {
+				waiting++
+				continue
+			}
+		}
+
+		if err := s.rollupWindow(level, start, end); err != nil {
+			return done, fmt.Errorf("rollup %s %s: %w", level.Size, start.Format(time.RFC3339), err)
+		}
+		done++
+	}
+	if waiting > 0 {
+		log.Printf("rollup %s: %d windows wait for %s source windows", level.Size, waiting, level.Source)
+	}
+	return done, nil
+}
+
+// rollupWindow заменяет строки окна свёрткой источников: суммы складываются,
+// максимумы берутся максимумом, средние длительности взвешиваются числом
+// вызовов, средняя память - длиной окна (пустые окна-источники - нулевая
+// память), поэтому avg_memory_mb × длина окна = сумма total_memory_mb_hours.
+// p95 свёртки - максимум p95 источников (оценка сверху).
+func (s *RollupService) rollupWindow(level RollupLevel, start, end time.Time) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("window_size = ? AND window_start >= ? AND window_start < ?", level.Size, start, end).
+			Delete(&models.UsageAggregate{}).Error; err != nil {
+			return err
+		}
+		res := tx.Exec(`
+			INSERT INTO usage_aggregates (
+			window_start, window_end, window_size,
+			tenant_id, service_id, revision_id,
+			invocations, total_duration_ms, billable_duration_ms,
+			avg_duration_ms, p50_duration_ms, p95_duration_ms,
+			max_memory_mb, avg_memory_mb, total_memory_mb_hours, memory_samples,
+			cold_starts, cold_start_init_ms, cold_start_gb_seconds,
+			errors, egress_bytes, total_cpu_ms
+			)
+			SELECT
+			?, ?, ?,
+			tenant_id, service_id, revision_id,
+			SUM(invocations), SUM(total_duration_ms), SUM(billable_duration_ms),
+			COALESCE(SUM(avg_duration_ms * invocations) / NULLIF(SUM(invocations), 0), AVG(avg_duration_ms)),
+			COALESCE(SUM(p50_duration_ms * invocations) / NULLIF(SUM(invocations), 0), AVG(p50_duration_ms)),
+			MAX(p95_duration_ms),
+			MAX(max_memory_mb),
+			SUM(avg_memory_mb * EXTRACT(EPOCH FROM window_end - window_start)) / ?,
+			SUM(total_memory_mb_hours), SUM(memory_samples),
+			SUM(cold_starts), SUM(cold_start_init_ms), SUM(cold_start_gb_seconds),
+			SUM(errors), SUM(egress_bytes), SUM(total_cpu_ms)
+			FROM usage_aggregates
+			WHERE window_size = ? AND window_start >= ? AND window_start < ?
+			GROUP BY tenant_id, service_id, revision_id`,
+			start, end, level.Size,
+			end.Sub(start).Seconds(),
+			level.Source, start, end,
+		)
+		if res.Error != nil {
+			return res.Error
+		}
+		return aggregation.MarkWindowComplete(tx, level.Size, start, end, level.Source, int(res.RowsAffected))
+	})
+}
+
+// ApplyRetention удаляет агрегаты и отметки окон старше срока хранения
+// своего размера. Размер, из которого строится свёртка, удаляется только
+// там, где свёртка уже есть, иначе использование было бы потеряно.
+func (s *RollupService) ApplyRetention(now time.Time, retention map[string]time.Duration, levels []RollupLevel) (int64, error) {
+	var total int64
+	for size, keep := range retention {
+		if keep <= 0 {
+			continue
+		}
+		cutoff := now.Add(-keep)
+
+		// table - таблица или алиас удаляемых строк
+		covered := func(table string) string { return "" }
+		args := []interface{}{size, cutoff}
+		for _, l := range levels {
+			if l.Source == size {
+				covered = func(table string) string {
+					return fmt.Sprintf(` AND EXISTS (
+						SELECT 1 FROM aggregation_windows r
+						WHERE r.window_size = ? AND r.source = ?
+						AND r.window_start <= %[1]s.window_start AND r.window_end >= %[1]s.window_end)`, table)
+				}
+				args = append(args, l.Size, l.Source)
+				break
+			}
+		}
+
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			res := tx.Exec(`DELETE FROM usage_aggregates WHERE window_size = ? AND window_end <= ?`+
+				covered("usage_aggregates"), args...)
+			if res.Error != nil {
+				return res.Error
+			}
+			total += res.RowsAffected
+			return tx.Exec(`DELETE FROM aggregation_windows m WHERE m.window_size = ? AND m.window_end <= ?`+
+				covered("m"), args...).Error
+		})
+		if err != nil {
+			return total, fmt.Errorf("retention %s: %w", size, err)
+		}
+	}
+	return total, nil
+}
+
+// ParseRetention разбирает "1m=7d,1h=90d"; 0 - хранить всегда
+func ParseRetention(s string) (map[string]time.Duration, error) {
+	out := map[string]time.Duration{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		size, keep, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid retention %q, expected size=duration", part)
+		}
+		if _, err := aggregation.ParseWindowSize(size); err != nil {
+			return nil, err
+		}
+		if keep == "0" {
+			out[size] = 0
+			continue
+		}
+		if days, isDays := strings.CutSuffix(keep, "d"); isDays {
+			n, err := strconv.Atoi(days)
+			if err != nil {
+				return nil, fmt.Errorf("invalid retention %q", part)
+			}
+			out[size] = time.Duration(n) * 24 * time.Hour
+			continue
+		}
+		d, err := time.ParseDuration(keep)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention %q", part)
+		}
+		out[size] = d
+	}
+	return out, nil
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/lypolix/FaaS-billing/internal/models"
+	"gorm.io/gorm"
+)
+
+var ErrTaxConfig = errors.New("invalid tax configuration")
+
+// loadTaxProfile возвращает налоговый профиль арендатора или nil,
+// если профиль не назначен
+func loadTaxProfile(db *gorm.DB, tenant models.Tenant) (*models.TaxProfile, error) {
+	if tenant.TaxProfileID == nil {
+		return nil, nil
+	}
+	var profile models.TaxProfile
+	if err := db.First(&profile, "id = ?", *tenant.TaxProfileID).Error; err != nil {
+		return nil, fmt.Errorf("tax profile not found: %w", err)
+	}
+	return &profile, nil
+}
+
+// applyTax считает налог от суммы amount. Если цены плана включают налог,
+// налог выделяется из суммы (amount × rate / (1 + rate)), иначе
+// начисляется сверху. Возвращает сумму без налога, налог и итог.
+// При exempt и reverse_charge налог не начисляется, а включённый в цены
+// налог вычитается по ставке профиля (amount / (1 + rate)); без ставки
+// такая комбинация - ошибка настройки.
+func applyTax(amount float64, profile *models.TaxProfile, inclusive bool) (subtotal, tax, total float64, summary models.TaxSummary, err error) {
+	amount = roundMoney(amount)
+	if profile == nil {
+		return amount, 0, amount, models.TaxSummary{}, nil
+	}
+
+	summary = models.TaxSummary{
+		ProfileID: &profile.ID,
+		Code:      profile.Code,
+		Mode:      profile.Mode,
+		Rate:      profile.Rate,
+		Inclusive: inclusive,
+	}
+	if profile.Mode != models.TaxModeStandard {
+		// exempt и reverse_charge: налог в счёте не начисляется
+		summary.Rate = 0
+		if inclusive {
+			if profile.Rate <= 0 {
+				return 0, 0, 0, summary, fmt.Errorf("%w: plan prices include tax, but tax profile %s has no rate to deduct", ErrTaxConfig, profile.Code)
+			}
+			amount = roundMoney(amount / (1 + profile.Rate))
+		}
+		return amount, 0, amount, summary, nil
+	}
+	if profile.Rate <= 0 {
+		return amount, 0, amount, summary, nil
+	}
+
+	if inclusive {
+		tax = roundMoney(amount * profile.Rate / (1 + profile.Rate))
+		subtotal = roundMoney(amount - tax)
+		total = amount
+	} else {
+		subtotal = amount
+		tax = roundMoney(amount * profile.Rate)
+		total = roundMoney(amount + tax)
+	}
+	summary.Amount = tax
+	return subtotal, tax, total, summary, nil
+}
+
+func roundMoney(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/lypolix/FaaS-billing/internal/models"
+)
+
+func TestApplyTax(t *testing.T) {
+	profile := func(mode string, rate float64) *models.TaxProfile {
+		return &models.TaxProfile{Code: "TEST", Mode: mode, Rate: rate}
+	}
+	tests := []struct {
+		name                 string
+		amount               float64
+		profile              *models.TaxProfile
+		inclusive            bool
+		subtotal, tax, total float64
+		err                  error
+	}{
+		{name: "no profile", amount: 100.004, subtotal: 100, total: 100},
+		{name: "standard on top", amount: 100, profile: profile(models.TaxModeStandard, 0.2), subtotal: 100, tax: 20, total: 120},
+		{name: "standard included", amount: 120, profile: profile(models.TaxModeStandard, 0.2), inclusive: true, subtotal: 100, tax: 20, total: 120},
+		{name: "standard zero rate", amount: 100, profile: profile(models.TaxModeStandard, 0), subtotal: 100, total: 100},
+		{name: "exempt", amount: 100, profile: profile(models.TaxModeExempt, 0.2), subtotal: 100, total: 100},
+		{name: "reverse charge deducts included tax", amount: 120, profile: profile(models.TaxModeReverseCharge, 0.2), inclusive: true, subtotal: 100, total: 100},
+		{name: "exempt included without rate", amount: 120, profile: profile(models.TaxModeExempt, 0), inclusive: true, err: ErrTaxConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subtotal, tax, total, summary, err := applyTax(tt.amount, tt.profile, tt.inclusive)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if subtotal != tt.subtotal || tax != tt.tax || total != tt.total {
+				t.Errorf("got %v/%v/%v, want %v/%v/%v", subtotal, tax, total, tt.subtotal, tt.tax, tt.total)
+			}
+			if summary.Amount != tt.tax {
+				t.Errorf("summary amount = %v, want %v", summary.Amount, tt.tax)
+			}
+			if tt.profile != nil && tt.profile.Mode != models.TaxModeStandard && summary.Rate != 0 {
+				t.Errorf("summary rate = %v, want 0 for %s", summary.Rate, tt.profile.Mode)
+			}
+		})
+	}
+}
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lypolix/FaaS-billing/internal/services/aggregation"
+)
+
+var ErrInvalidUsageQuery = errors.New("invalid usage query")
+
+// maxUsageBuckets ограничивает длину ряда: (end-start)/step
+const maxUsageBuckets = 10_000
+
+// usageMeasures - меры запроса и их свёртка по агрегатам. Перцентиль
+// нельзя сложить точно, поэтому p95 интервала - максимум p95 окон (оценка сверху).
+var usageMeasures = map[string]string{
+	"invocations": "SUM(a.invocations)",
+	"gb_hours":    "SUM(a.total_memory_mb_hours) / 1024.0",
+	"p95":         "MAX(a.p95_duration_ms)",
+	"cold_starts": "SUM(a.cold_starts)",
+	"egress":      "SUM(a.egress_bytes)",
+	"errors":      "SUM(a.errors)",
+}
+
+// usageGroups - измерения группировки; label:<key> - метка сервиса
+var usageGroups = map[string]string{
+	"tenant":   "a.tenant_id::text",
+	"service":  "a.service_id::text",
+	"revision": "a.revision_id::text",
+}
+
+// UsageQuery - запрос ряда использования. GroupBy: tenant, service,
+// revision, label:<key>; Measures: ключи usageMeasures.
+type UsageQuery struct {
+	Start, End time.Time
+	Step       time.Duration
+	GroupBy    []string
+	Measures   []string
+	TenantID   *uuid.UUID
+	ServiceID  *uuid.UUID
+	RevisionID *uuid.UUID
+}
+
+type UsagePoint struct {
+	Start  time.Time          `json:"start"`
+	Values map[string]float64 `json:"values"`
+}
+
+type UsageSeriesGroup struct {
+	Group  map[string]string `json:"group,omitempty"` // измерение -> значение
+	Points []UsagePoint      `json:"points"`
+}
+
+// UsageSeries - ответ запроса. WindowSizes - размеры окон, из которых
+// собран ряд (от крупного к мелкому); в точках только интервалы с данными.
+type UsageSeries struct {
+	Start       time.Time          `json:"start"`
+	End         time.Time          `json:"end"`
+	Step        string             `json:"step"`
+	WindowSizes []string           `json:"window_sizes"`
+	GroupBy     []string           `json:"group_by,omitempty"`
+	Measures    []string           `json:"measures"`
+	Series      []UsageSeriesGroup `json:"series"`
+}
+
+// ParseStep разбирает шаг ряда: длительность Go ("15m", "1h") или дни ("1d", "7d")
+func ParseStep(s string) (time.Duration, error) {
+	if d, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(d)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("%w: invalid step %q", ErrInvalidUsageQuery, s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	step, err := time.ParseDuration(s)
+	if err != nil || step < time.Minute || step%time.Second != 0 {
+		return 0, fmt.Errorf("%w: step must be whole seconds, at least 1m, got %q", ErrInvalidUsageQuery, s)
+	}
+	return step, nil
+}
+
+// QueryUsage сворачивает usage_aggregates в интервалы шага на стороне БД.
+// Для каждого участка диапазона берётся самый крупный размер окна, который
+// делит шаг и уже посчитан: крупные окна покрывают начало диапазона до
+// своего последнего window_end, остаток добирается более мелкими. Строки
+// разных размеров за один и тот же период не складываются.
+func (s *MetricsService) QueryUsage(q UsageQuery) (*UsageSeries, error) {
+	if q.Step <= 0 {
+		q.Step = time.Hour
+	}
+	if len(q.Measures) == 0 {
+		q.Measures = []string{"invocations", "gb_hours"}
+	}
+	for _, m := range q.Measures {
+		if _, ok := usageMeasures[m]; !ok {
+			return nil, fmt.Errorf("%w: unknown measure %q", ErrInvalidUsageQuery, m)
+		}
+	}
+	// начало выравнивается по шагу от эпохи UTC: тогда окна агрегатора,
+	// делящие шаг, целиком попадают в один интервал
+	q.Start = q.Start.UTC().Truncate(q.Step)
+	q.End = q.End.UTC()
+	if !q.Start.Before(q.End) {
+		return nil, fmt.Errorf("%w: start must be before end", ErrInvalidUsageQuery)
+	}
+	if q.End.Sub(q.Start)/q.Step > maxUsageBuckets {
+		return nil, fmt.Errorf("%w: more than %d steps in range", ErrInvalidUsageQuery, maxUsageBuckets)
+	}
+
+	var (
+		selects, groupCols []string
+		selectArgs         []interface{}
+		joinServices       bool
+	)
+	for i, g := range q.GroupBy {
+		col := fmt.Sprintf("g%d", i)
+		expr, ok := usageGroups[g]
+		if key, isLabel := strings.CutPrefix(g, "label:"); isLabel && key != "" {
+			expr, ok = "s.labels ->> ?", true
+			selectArgs = append(selectArgs, key)
+			joinServices = true
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown group_by %q", ErrInvalidUsageQuery, g)
+		}
+		selects = append(selects, expr+" AS "+col)
+		groupCols = append(groupCols, col)
+	}
+	for i, m := range q.Measures {
+		selects = append(selects, fmt.Sprintf("COALESCE(%s, 0) AS m%d", usageMeasures[m], i))
+	}
+
+	where := []string{"a.window_start >= ?", "a.window_start < ?"}
+	args := []interface{}{q.Start, q.End}
+	if q.TenantID != nil {
+		where, args = append(where, "a.tenant_id = ?"), append(args, *q.TenantID)
+	}
+	if q.ServiceID != nil {
+		where, args = append(where, "a.service_id = ?"), append(args, *q.ServiceID)
+	}
+	if q.RevisionID != nil {
+		where, args = append(where, "a.revision_id = ?"), append(args, *q.RevisionID)
+	}
+
+	series := &UsageSeries{
+		Start:       q.Start,
+		End:         q.End,
+		Step:        formatStep(q.Step),
+		WindowSizes: []string{},
+		GroupBy:     q.GroupBy,
+		Measures:    q.Measures,
+		Series:      []UsageSeriesGroup{},
+	}
+
+	tiers, tierArgs, sizes, err := s.usageTiers(q, where, args)
+	if err != nil || len(tiers) == 0 {
+		return series, err
+	}
+	series.WindowSizes = sizes
+	where = append(where, "("+strings.Join(tiers, " OR ")+")")
+	args = append(args, tierArgs...)
+
+	// номер интервала - то же, что date_bin(step, window_start, start), но
+	// без date_bin, которого нет в PostgreSQL 13 (deployment/postgres)
+	stepSec := int64(q.Step / time.Second)
+	sqlText := "SELECT floor(extract(epoch FROM a.window_start - ?::timestamptz) / ?)::bigint AS bucket"
+	if len(selects) > 0 {
+		sqlText += ", " + strings.Join(selects, ", ")
+	}
+	sqlText += " FROM usage_aggregates a"
+	if joinServices {
+		sqlText += " JOIN services s ON s.id = a.service_id"
+	}
+	sqlText += " WHERE " + strings.Join(where, " AND ")
+	sqlText += " GROUP BY " + strings.Join(append([]string{"bucket"}, groupCols...), ", ")
+	sqlText += " ORDER BY " + strings.Join(append(groupCols, "bucket"), ", ")
+
+	allArgs := append([]interface{}{q.Start, stepSec}, selectArgs...)
+	allArgs = append(allArgs, args...)
+	rows, err := s.db.Raw(sqlText, allArgs...).Rows()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query usage: %w", err)
+	}
+	defer rows.Close()
+
+	var current *UsageSeriesGroup
+	var currentKey string
+	for rows.Next() {
+		var bucket int64
+		groups := make([]sql.NullString, len(groupCols))
+		values := make([]float64, len(q.Measures))
+		dest := []interface{}{&bucket}
+		for i := range groups {
+			dest = append(dest, &groups[i])
+		}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		key := fmt.Sprint(groups)
+		if current == nil || key != currentKey {
+			g := UsageSeriesGroup{Points: []UsagePoint{}}
+			if len(groups) > 0 {
+				g.Group = make(map[string]string, len(groups))
+				for i, v := range groups {
+					g.Group[q.GroupBy[i]] = v.String
+				}
+			}
+			series.Series = append(series.Series, g)
+			current, currentKey = &series.Series[len(series.Series)-1], key
+		}
+		p := UsagePoint{Start: q.Start.Add(time.Duration(bucket) * q.Step), Values: make(map[string]float64, len(values))}
+		for i, m := range q.Measures {
+			p.Values[m] = values[i]
+		}
+		current.Points = append(current.Points, p)
+	}
+	return series, rows.Err()
+}
+
+// usageTiers выбирает размеры окон для участков диапазона и возвращает
+// условия вида (window_size = ? AND window_start >= ? AND window_start < ?)
+func (s *MetricsService) usageTiers(q UsageQuery, where []string, args []interface{}) ([]string, []interface{}, []string, error) {
+	type available struct {
+		WindowSize string
+		MaxEnd     time.Time
+	}
+	var avail []available
+	if err := s.db.Raw(
+		"SELECT a.window_size, MAX(a.window_end) AS max_end FROM usage_aggregates a WHERE "+
+			strings.Join(where, " AND ")+" GROUP BY a.window_size",
+		args...,
+	).Scan(&avail).Error; err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get window sizes: %w", err)
+	}
+	if len(avail) == 0 {
+		return nil, nil, nil, nil
+	}
+
+	type tier struct {
+		size   string
+		dur    time.Duration
+		maxEnd time.Time
+	}
+	var usable []tier
+	for _, a := range avail {
+		d, err := aggregation.ParseWindowSize(a.WindowSize)
+		if err != nil || q.Step%d != 0 {
+			continue
+		}
+		usable = append(usable, tier{a.WindowSize, d, a.MaxEnd})
+	}
+	if len(usable) == 0 {
+		return nil, nil, nil, fmt.Errorf("%w: step %s is not a multiple of any stored window size", ErrInvalidUsageQuery, formatStep(q.Step))
+	}
+	sort.Slice(usable, func(i, j int) bool { return usable[i].dur > usable[j].dur })
+
+	var (
+		conds []string
+		cargs []interface{}
+		sizes []string
+	)
+	from := q.Start
+	for i, t := range usable {
+		until := t.maxEnd
+		if i == len(usable)-1 || until.After(q.End) {
+			until = q.End
+		}
+		if !until.After(from) {
+			continue
+		}
+		conds = append(conds, "(a.window_size = ? AND a.window_start >= ? AND a.window_start < ?)")
+		cargs = append(cargs, t.size, from, until)
+		sizes = append(sizes, t.size)
+		from = until
+	}
+	return conds, cargs, sizes, nil
+}
+
+func formatStep(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	s := d.String() // "1h0m0s", "1h30m0s", "15m0s"
+	s = strings.TrimSuffix(s, "0s")
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
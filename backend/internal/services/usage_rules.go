@@ -0,0 +1,41 @@
+package services
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lypolix/FaaS-billing/internal/models"
+	"github.com/lypolix/FaaS-billing/internal/services/aggregation"
+	"gorm.io/gorm"
+)
+
+// UsageRulesAt возвращает правила плана, действовавшего у арендатора в момент at
+func UsageRulesAt(db *gorm.DB, tenantID uuid.UUID, at time.Time) aggregation.UsageRules {
+	var tenant models.Tenant
+	if err := db.First(&tenant, "id = ?", tenantID).Error; err != nil {
+		return aggregation.DefaultUsageRules
+	}
+	segments, err := planSegments(db, tenant, at, at.Add(time.Nanosecond))
+	if err != nil {
+		return aggregation.DefaultUsageRules
+	}
+	return rulesFromPlan(segments[0].Plan)
+}
+
+func rulesFromPlan(p models.PricingPlan) aggregation.UsageRules {
+	r := aggregation.UsageRules{
+		MemoryMode:    p.MemoryBillingMode,
+		MinDurationMS: p.MinBillableDurationMS,
+		RoundingMS:    p.DurationRoundingMS,
+	}
+	if r.MemoryMode != models.MemoryBillingAllocated {
+		r.MemoryMode = models.MemoryBillingMeasured
+	}
+	if r.MinDurationMS < 0 {
+		r.MinDurationMS = 0
+	}
+	if r.RoundingMS < 1 {
+		r.RoundingMS = 1
+	}
+	return r
+}
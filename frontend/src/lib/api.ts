@@ -22,6 +22,7 @@ export type Service = {
   tenant_id: UUID
   name: string
   description?: string
+  labels?: Record<string, string>
   created_at?: string
   updated_at?: string
 }
@@ -37,8 +38,57 @@ export type UsageAggregate = {
   cost?: number
 }
 
-export type UsageAggregatesResponse = {
-  data: UsageAggregate[]
+// Конверт списков: next_cursor передаётся в cursor для следующей страницы
+export type Page<T> = {
+  data: T[]
+  next_cursor?: string
+}
+
+export type PageParams = {
+  limit?: number
+  sort?: string // "-created_at" - по убыванию
+  cursor?: string
+}
+
+export type UsageAggregatesResponse = Page<UsageAggregate>
+
+export type UsageMeasure =
+  | "invocations"
+  | "gb_hours"
+  | "p95"
+  | "cold_starts"
+  | "egress"
+  | "errors"
+
+export type UsageSeries = {
+  start: string
+  end: string
+  step: string
+  window_sizes: string[] // из каких размеров окон собран ряд
+  group_by?: string[]
+  measures: UsageMeasure[]
+  series: {
+    group?: Record<string, string>
+    points: { start: string; values: Partial<Record<UsageMeasure, number>> }[]
+  }[]
+}
+
+export type CostPoint = {
+  start: string
+  end: string
+  cost: number
+  cumulative: number // нарастающий итог с начала месяца
+  by_service?: Record<UUID, number>
+}
+
+export type CostSeries = {
+  tenant_id: UUID
+  currency: string
+  granularity: "day" | "hour"
+  start: string
+  end: string
+  total: number
+  points: CostPoint[]
 }
 
 export type CalculateBillRequest = {
@@ -52,9 +102,9 @@ export async function health() {
   return data as { status: string }
 }
 
-export async function listTenants() {
-  const { data } = await api.get("/tenants")
-  return data as Tenant[]
+export async function listTenants(params?: PageParams) {
+  const { data } = await api.get("/tenants", { params })
+  return (data as Page<Tenant>).data
 }
 
 export async function createTenant(payload: Partial<Tenant>) {
@@ -62,9 +112,9 @@ export async function createTenant(payload: Partial<Tenant>) {
   return data as Tenant
 }
 
-export async function listServices(params?: { tenant_id?: string }) {
+export async function listServices(params?: { tenant_id?: string } & PageParams) {
   const { data } = await api.get("/services", { params })
-  return data as Service[]
+  return (data as Page<Service>).data
 }
 
 export async function createService(payload: Partial<Service>) {
@@ -77,11 +127,40 @@ export async function getUsageAggregates(params?: {
   service_id?: string
   start_time?: string
   end_time?: string
-}) {
+  window_size?: string
+  revision_id?: string
+} & PageParams) {
   const { data } = await api.get("/usage-aggregates", { params })
   return data as UsageAggregatesResponse
 }
 
+export async function queryUsage(params: {
+  start: string // RFC3339
+  end: string // RFC3339
+  step?: string // "1h", "1d"
+  group_by?: string // "service,label:team"
+  measures?: string // "invocations,gb_hours"
+  tenant_id?: string
+  service_id?: string
+  revision_id?: string
+}) {
+  const { data } = await api.get("/usage-aggregates/query", { params })
+  return data as UsageSeries
+}
+
+export async function getTenantCosts(
+  tenantId: UUID,
+  params?: {
+    granularity?: "day" | "hour"
+    start?: string // RFC3339
+    end?: string // RFC3339
+    group_by?: "service"
+  },
+) {
+  const { data } = await api.get(`/tenants/${tenantId}/costs`, { params })
+  return data as CostSeries
+}
+
 export async function calculateBill(payload: CalculateBillRequest) {
   const { data } = await api.post("/billing/calculate", payload)
   return data
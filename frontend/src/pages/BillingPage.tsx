@@ -29,7 +29,7 @@ function StatCard({
 }
 
 export default function BillingPage() {
-  const tenantsQ = useQuery({ queryKey: ["tenants"], queryFn: listTenants })
+  const tenantsQ = useQuery({ queryKey: ["tenants"], queryFn: () => listTenants() })
   const servicesQ = useQuery({
     queryKey: ["services"],
     queryFn: () => listServices(),
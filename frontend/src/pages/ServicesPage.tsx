@@ -15,7 +15,7 @@ export default function ServicesPage() {
   const qc = useQueryClient()
   const [localFiles, setLocalFiles] = useState<Record<string, string>>({})
 
-  const tenantsQ = useQuery({ queryKey: ["tenants"], queryFn: listTenants })
+  const tenantsQ = useQuery({ queryKey: ["tenants"], queryFn: () => listTenants() })
   const servicesQ = useQuery({ queryKey: ["services"], queryFn: () => listServices() })
 
   const { register, handleSubmit, watch, reset } = useForm<UploadForm>({
@@ -10,7 +10,7 @@ export default function TenantsPage() {
 
   const tenantsQ = useQuery({
     queryKey: ["tenants"],
-    queryFn: listTenants,
+    queryFn: () => listTenants(),
   })
 
   const { register, handleSubmit, reset } = useForm<FormValues>({
@@ -12,6 +12,8 @@ import (
 	"github.com/go-redis/redis/v8"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/lypolix/FaaS-billing/spendcap"
 )
 
 type MetricEvent struct {
@@ -66,6 +68,10 @@ func main() {
 	queueKey = getEnv("REDIS_QUEUE_KEY", "metrics_queue")
 
 	r := gin.New()
+	// приостановки по жёстким лимитам расходов (backend /api/v1/suspensions):
+	// события приостановленных арендаторов и сервисов не принимаются, а в
+	// режиме sidecar (UPSTREAM_URL) вызовы функции получают 402
+	suspensions := spendcap.Sidecar(r, getEnv)
 	r.Use(func(c *gin.Context) {
 		c.Next()
 		reqTotal.WithLabelValues(c.Request.Method, c.FullPath(), http.StatusText(c.Writer.Status())).Inc()
@@ -95,7 +101,7 @@ func main() {
 		}
 
 		ctx := context.Background()
-		accepted := 0
+		accepted, rejected := 0, 0
 		for _, ev := range arr {
 			if ev.Timestamp.IsZero() {
 				ev.Timestamp = time.Now().UTC()
@@ -109,6 +115,11 @@ func main() {
 			if ev.Revision == "" {
 				ev.Revision = getEnv("DEFAULT_REVISION", "rev-unknown")
 			}
+			if suspensions.Suspended(ev.TenantID, ev.ServiceName) {
+				ingestErr.WithLabelValues("suspended").Inc()
+				rejected++
+				continue
+			}
 			b, err := json.Marshal(ev)
 			if err != nil {
 				ingestErr.WithLabelValues("marshal").Inc()
@@ -121,7 +132,11 @@ func main() {
 			ingestTotal.WithLabelValues(ev.TenantID, ev.ServiceName, ev.Revision).Inc()
 			accepted++
 		}
-		c.JSON(200, gin.H{"accepted": accepted, "total": len(arr)})
+		if rejected > 0 && accepted == 0 {
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": "spending cap exceeded", "rejected": rejected, "total": len(arr)})
+			return
+		}
+		c.JSON(200, gin.H{"accepted": accepted, "rejected": rejected, "total": len(arr)})
 	})
 
 	// Выдача события потребителю (по одному)
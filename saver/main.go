@@ -12,6 +12,8 @@ import (
 	"github.com/go-redis/redis/v8"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/lypolix/FaaS-billing/spendcap"
 )
 
 var (
@@ -63,6 +65,10 @@ func main() {
 	prometheus.MustRegister(ingestTotal, ingestErrors, ingestLatency)
 
 	r := gin.Default()
+	// приостановки по жёстким лимитам расходов (backend /api/v1/suspensions):
+	// события приостановленных арендаторов и сервисов не принимаются, а в
+	// режиме sidecar (UPSTREAM_URL) вызовы функции получают 402
+	suspensions := spendcap.Sidecar(r, getEnv)
 	r.GET("/healthz", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"status":  "ok",
@@ -111,7 +117,7 @@ func main() {
 		}
 
 		ctx := context.Background()
-		pushed := 0
+		pushed, rejected := 0, 0
 		for _, ev := range batch {
 			// sane defaults
 			if ev.Timestamp.IsZero() {
@@ -126,6 +132,11 @@ func main() {
 			if ev.Revision == "" {
 				ev.Revision = getEnv("DEFAULT_REVISION", "rev-unknown")
 			}
+			if suspensions.Suspended(ev.TenantID, ev.ServiceName) {
+				ingestErrors.WithLabelValues("suspended").Inc()
+				rejected++
+				continue
+			}
 
 			data, err := json.Marshal(ev)
 			if err != nil {
@@ -140,8 +151,17 @@ func main() {
 			pushed++
 		}
 
+		if rejected > 0 && pushed == 0 {
+			c.JSON(http.StatusPaymentRequired, gin.H{
+				"error":    "spending cap exceeded",
+				"rejected": rejected,
+				"total":    len(batch),
+			})
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{
 			"accepted": pushed,
+			"rejected": rejected,
 			"total":    len(batch),
 		})
 	})
@@ -0,0 +1,34 @@
+package spendcap
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// Guard отвечает 402 на вызовы сервиса serviceName арендатора tenantID, пока
+// он приостановлен, остальные передаёт next. Арендатор и сервис задаются
+// конфигурацией sidecar, а не заголовками запроса, чтобы вызывающий не мог
+// обойти приостановку.
+func Guard(cache *Cache, tenantID, serviceName string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cache.Suspended(tenantID, serviceName) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusPaymentRequired)
+			json.NewEncoder(w).Encode(map[string]string{"error": "spending cap exceeded"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NewProxy - обратный прокси вызовов к контейнеру функции upstream с
+// проверкой приостановки
+func NewProxy(cache *Cache, upstream string, tenantID, serviceName string) (http.Handler, error) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, err
+	}
+	return Guard(cache, tenantID, serviceName, httputil.NewSingleHostReverseProxy(u)), nil
+}
@@ -0,0 +1,43 @@
+package spendcap
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Sidecar запускает фоновое обновление списка приостановок (SUSPENSIONS_URL,
+// SUSPENSIONS_TTL) и возвращает кэш для проверки приёма событий. Если задан
+// UPSTREAM_URL, все прочие маршруты r проксируются в контейнер функции с
+// ответом 402 на время приостановки. Приостановки хранятся по UUID
+// арендатора, поэтому в этом режиме TENANT_ID обязателен; без него или с
+// невалидным значением процесс завершается при старте. SERVICE_NAME
+// (по умолчанию DEFAULT_SERVICE) пуст - действуют только приостановки
+// арендатора целиком.
+func Sidecar(r *gin.Engine, getenv func(key, def string) string) *Cache {
+	cache := NewCache(getenv("SUSPENSIONS_URL", ""))
+	ttl, err := time.ParseDuration(getenv("SUSPENSIONS_TTL", "30s"))
+	if err != nil {
+		log.Fatalf("invalid SUSPENSIONS_TTL: %v", err)
+	}
+	go cache.Run(context.Background(), ttl)
+
+	upstream := getenv("UPSTREAM_URL", "")
+	if upstream == "" {
+		return cache
+	}
+	tenantID, err := uuid.Parse(getenv("TENANT_ID", ""))
+	if err != nil {
+		log.Fatalf("UPSTREAM_URL requires TENANT_ID with the tenant UUID: %v", err)
+	}
+	proxy, err := NewProxy(cache, upstream, tenantID.String(),
+		getenv("SERVICE_NAME", getenv("DEFAULT_SERVICE", "")))
+	if err != nil {
+		log.Fatalf("invalid UPSTREAM_URL: %v", err)
+	}
+	r.NoRoute(gin.WrapH(proxy))
+	return cache
+}
@@ -0,0 +1,98 @@
+// Package spendcap - приостановки по жёстким лимитам расходов для data plane:
+// кэш списка из GET /api/v1/suspensions бэкенда и прокси вызовов, который
+// не пускает к функции приостановленных арендаторов.
+package spendcap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// suspension - запись из GET /api/v1/suspensions бэкенда
+type suspension struct {
+	TenantID    string `json:"tenant_id"`
+	ServiceName string `json:"service_name"` // пусто = весь арендатор
+}
+
+// Cache хранит список приостановленных по лимиту арендаторов и сервисов.
+// Список обновляется в фоне; при ошибке бэкенда остаётся прежний.
+type Cache struct {
+	url    string
+	client *http.Client
+
+	mu       sync.RWMutex
+	tenants  map[string]bool
+	services map[string]bool // tenant_id + "/" + service_name
+}
+
+func NewCache(url string) *Cache {
+	return &Cache{
+		url:      url,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		tenants:  map[string]bool{},
+		services: map[string]bool{},
+	}
+}
+
+// Run обновляет список каждые ttl, пока не отменён ctx
+func (s *Cache) Run(ctx context.Context, ttl time.Duration) {
+	if s.url == "" {
+		return
+	}
+	t := time.NewTicker(ttl)
+	defer t.Stop()
+	for {
+		if err := s.refresh(ctx); err != nil {
+			log.Printf("suspensions refresh failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (s *Cache) refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var list []suspension
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return err
+	}
+
+	tenants, services := map[string]bool{}, map[string]bool{}
+	for _, sp := range list {
+		if sp.ServiceName == "" {
+			tenants[sp.TenantID] = true
+		} else {
+			services[sp.TenantID+"/"+sp.ServiceName] = true
+		}
+	}
+	s.mu.Lock()
+	s.tenants, s.services = tenants, services
+	s.mu.Unlock()
+	return nil
+}
+
+// Suspended сообщает, приостановлен ли арендатор целиком или его сервис
+func (s *Cache) Suspended(tenantID, serviceName string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tenants[tenantID] || s.services[tenantID+"/"+serviceName]
+}